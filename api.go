@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterh/comprod2/state"
+)
+
+const apiPrefix = "/api/v1/"
+
+// api serves the JSON interface to the game under /api/v1/. Every request
+// must be authenticated, either by the id cookie set at login, or by a
+// bearer token.
+type api struct {
+	g *state.Game
+}
+
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type apiStock struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type apiLeader struct {
+	Name  string `json:"name"`
+	Worth uint64 `json:"worth"`
+}
+
+type apiHistory struct {
+	Date string `json:"date"`
+	Text string `json:"text"`
+}
+
+type apiHolding struct {
+	Stock  string `json:"stock"`
+	Price  uint64 `json:"price"`
+	Shares uint64 `json:"shares"`
+	Short  uint64 `json:"short"`
+}
+
+type apiStats struct {
+	Name          string  `json:"name"`
+	Seasons       int     `json:"seasons"`
+	Wins          int     `json:"wins"`
+	Podiums       int     `json:"podiums"`
+	BestWorth     uint64  `json:"best_worth"`
+	AverageWorth  uint64  `json:"average_worth"`
+	AverageRank   float64 `json:"average_rank"`
+	LongestStreak int     `json:"longest_streak"`
+	CurrentStreak int     `json:"current_streak"`
+	Rating        int     `json:"rating"`
+}
+
+type apiPortfolio struct {
+	Name     string       `json:"name"`
+	Cash     uint64       `json:"cash"`
+	NetWorth uint64       `json:"net_worth"`
+	Holdings []apiHolding `json:"holdings"`
+}
+
+type apiTrade struct {
+	Stock string `json:"stock"`
+	Lots  uint64 `json:"lots"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	var e apiError
+	e.Error.Code = code
+	e.Error.Message = message
+	writeJSON(w, status, &e)
+}
+
+func portfolio(g *state.Game, name string, p *state.PlayerInfo) *apiPortfolio {
+	ph := p.Holdings()
+	rv := &apiPortfolio{Name: name, Cash: ph.Cash, Holdings: []apiHolding{}}
+	nw := ph.Cash
+	var owed uint64
+	for _, v := range g.ListStocks() {
+		h := apiHolding{Stock: v.Name, Price: v.Value, Shares: ph.Shares[v.Name], Short: ph.Short[v.Name]}
+		rv.Holdings = append(rv.Holdings, h)
+		nw += h.Shares * v.Value
+		owed += h.Short * v.Value
+	}
+	if owed > nw {
+		owed = nw
+	}
+	rv.NetWorth = nw - owed
+	return rv
+}
+
+func leaders(g *state.Game) []apiLeader {
+	l := g.Leaders()
+	sort.Sort(state.LeaderSort(l))
+	rv := []apiLeader{}
+	for _, v := range l {
+		rv = append(rv, apiLeader{v.Name, v.Worth})
+	}
+	return rv
+}
+
+// readTrade reads the trade requested by the body of r, which may be either
+// JSON or a form.
+func readTrade(w http.ResponseWriter, r *http.Request) (apiTrade, error) {
+	var t apiTrade
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json" {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&t)
+		return t, err
+	}
+	t.Stock = r.FormValue("stock")
+	lots, err := strconv.ParseUint(r.FormValue("lots"), 10, 64)
+	t.Lots = lots
+	return t, err
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := authenticate(a.g, r)
+	if p == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="comprod"`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Please log in, or supply a bearer token")
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	method := http.MethodGet
+	if endpoint == "buy" || endpoint == "sell" {
+		method = http.MethodPost
+	}
+	if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed on "+r.URL.Path)
+		return
+	}
+
+	switch endpoint {
+	case "stocks":
+		stocks := []apiStock{}
+		for _, v := range a.g.ListStocks() {
+			stocks = append(stocks, apiStock{v.Name, v.Value})
+		}
+		writeJSON(w, http.StatusOK, stocks)
+	case "news":
+		writeJSON(w, http.StatusOK, append([]string{}, a.g.News()...))
+	case "leaders":
+		writeJSON(w, http.StatusOK, leaders(a.g))
+	case "history":
+		history := []apiHistory{}
+		for _, v := range a.g.History() {
+			history = append(history, apiHistory{v.Date.Format(time.RFC3339), v.Text})
+		}
+		writeJSON(w, http.StatusOK, history)
+	case "portfolio":
+		writeJSON(w, http.StatusOK, portfolio(a.g, name, p))
+	case "stats":
+		who := r.FormValue("name")
+		if len(who) < 1 {
+			who = name
+		}
+		s := a.g.PlayerStats(who)
+		if s == nil {
+			writeAPIError(w, http.StatusNotFound, "not_found", who+" hasn't finished a season")
+			return
+		}
+		writeJSON(w, http.StatusOK, apiStats(*s))
+	case "buy", "sell":
+		t, err := readTrade(w, r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "Expected a stock and a whole number of lots")
+			return
+		}
+		if !p.CanTrade() {
+			writeAPIError(w, http.StatusForbidden, "forbidden", readOnly)
+			return
+		}
+		if endpoint == "buy" {
+			err = p.Buy(t.Stock, t.Lots)
+		} else {
+			err = p.Sell(t.Stock, t.Lots)
+		}
+		if err == state.ErrNoLots || err == state.ErrTooManyLots {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "rejected", err.Error())
+			return
+		}
+		a.g.Notify("trade")
+		writeJSON(w, http.StatusOK, portfolio(a.g, name, p))
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such endpoint: "+r.URL.Path)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func TestAPITradeLots(t *testing.T) {
+	game := state.Create(filepath.Join(t.TempDir(), "game.db"))
+	if game == nil {
+		t.Fatal("Unable to create the game")
+	}
+	defer game.Close()
+	p := game.NewPlayer("alice")
+	token, err := p.NewToken("test", state.ScopeTrade)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stock := game.ListStocks()[0].Name
+	a := &api{game}
+
+	for _, v := range []struct {
+		endpoint string
+		lots     uint64
+		status   int
+		code     string
+	}{
+		{"buy", 0, http.StatusBadRequest, "bad_request"},
+		{"sell", 0, http.StatusBadRequest, "bad_request"},
+		{"buy", math.MaxUint64, http.StatusBadRequest, "bad_request"},
+		{"sell", math.MaxUint64, http.StatusBadRequest, "bad_request"},
+		{"sell", 1, http.StatusUnprocessableEntity, "rejected"},
+		{"buy", 1, http.StatusOK, ""},
+	} {
+		body := fmt.Sprintf(`{"stock": %q, "lots": %d}`, stock, v.lots)
+		r := httptest.NewRequest("POST", apiPrefix+v.endpoint, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		var e apiError
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if w.Code != v.status || e.Error.Code != v.code {
+			t.Errorf("%s %d lots: %d %s, want %d %s", v.endpoint, v.lots, w.Code, e.Error.Code, v.status, v.code)
+		}
+	}
+	if l := p.Ledger(); len(l) != 1 || l[0].Reason != "buy" {
+		t.Errorf("alice's ledger is %+v", l)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/peterh/comprod2/state"
+)
+
+const (
+	chartWidth  = 600
+	chartHeight = 200
+	chartMargin = 40
+)
+
+// priceChart draws the price history in pp as an inline SVG line chart.
+// Splits are marked with a circle, and bankruptcies with a cross.
+func priceChart(pp []state.PricePoint) template.HTML {
+	if len(pp) < 1 {
+		return ""
+	}
+	value := []uint64{pp[0].Open}
+	max := pp[0].Open
+	for _, v := range pp {
+		value = append(value, v.Close)
+		if v.Open > max {
+			max = v.Open
+		}
+		if v.Close > max {
+			max = v.Close
+		}
+	}
+	if max < 1 {
+		max = 1
+	}
+	x := func(i int) float64 {
+		return chartMargin + float64(i)*float64(chartWidth-2*chartMargin)/float64(len(value)-1)
+	}
+	y := func(v uint64) float64 {
+		return chartHeight - chartMargin/2 - float64(v)*float64(chartHeight-chartMargin)/float64(max)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" width="%d" height="%d" viewBox="0 0 %d %d">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="axis"/>`, chartMargin, y(0), chartWidth-chartMargin, y(0))
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="axis"/>`, chartMargin, y(0), chartMargin, y(max))
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="label" text-anchor="end">$%d</text>`, chartMargin-4, y(max)+4, max)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="label" text-anchor="end">$0</text>`, chartMargin-4, y(0)+4)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label">%s</text>`, chartMargin, chartHeight-2, pp[0].Date.Format("Jan 2"))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="label" text-anchor="end">%s</text>`, chartWidth-chartMargin, chartHeight-2, pp[len(pp)-1].Date.Format("Jan 2"))
+
+	points := make([]string, 0, len(value))
+	for i, v := range value {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(i), y(v)))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" class="price"/>`, strings.Join(points, " "))
+
+	for i, v := range pp {
+		switch {
+		case v.Bankrupt:
+			cx, cy := x(i+1), y(0)
+			fmt.Fprintf(&b, `<path d="M%.1f,%.1f l8,8 m0,-8 l-8,8" class="bankrupt"><title>Bankrupt %s</title></path>`,
+				cx-4, cy-4, v.Date.Format("Jan 2"))
+		case v.Split > 0:
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="4" class="split"><title>Split %s</title></circle>`,
+				x(i+1), y(v.Close), v.Date.Format("Jan 2"))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
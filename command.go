@@ -12,10 +12,20 @@ var command = []struct {
 }{
 	{f: passwd, name: "adduser", desc: "<user> <password> Add a new user"},
 	{f: admin, name: "admin", desc: "<user> <true|false> Change admin status of user"},
+	{f: config, name: "config", desc: "[rule [value]] Show or change the rules of the game"},
 	{f: create, name: "create", desc: "Create new empty game"},
+	{f: importGob, name: "import-gob", desc: "<file> Create the game from the state file of the original comprod"},
 	{f: invite, name: "invite", desc: "<user> Invite a new user to the game"},
+	{f: migrate, name: "migrate", desc: "[-n] Upgrade the game's schema, after backing it up (-n for a dry run)"},
+	{f: model, name: "model", desc: "[model] Show or change the market model"},
 	{f: passwd, name: "passwd", desc: "<user> <password> Set a user's password"},
+	{f: replay, name: "replay", desc: "<date> Replay the market adjustments made on date"},
+	{f: schedule, name: "schedule", desc: "[schedule] Show or change when turns are played"},
+	{f: season, name: "season", desc: "[length <def> | add <first> <last> | remove <id> | extend <days> | end] Show or change the seasons"},
+	{f: simulate, name: "simulate", desc: "[-days N] [-seasons M] Simulate the market with scripted players"},
 	{f: start, name: "start", desc: "Start a web server to run the game"},
+	{f: timezone, name: "timezone", desc: "[zone] Show or change the game's time zone"},
+	{f: unlock, name: "unlock", desc: "[user|address] Show failed logins, or clear them for a user or address"},
 }
 
 func usage() {
@@ -4,6 +4,7 @@ import (
 	"crypto/subtle"
 	"embed"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
@@ -14,14 +15,16 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterh/comprod2/state"
 )
 
 type handler struct {
-	t   *template.Template
-	err *template.Template
-	g   *state.Game
+	t     *template.Template
+	err   *template.Template
+	login *template.Template
+	g     *state.Game
 }
 
 type errorReason struct {
@@ -29,7 +32,83 @@ type errorReason struct {
 }
 
 func login(w http.ResponseWriter, r *http.Request) {
-	http.Redirect(w, r, "/static/login.html", 307)
+	http.Redirect(w, r, "/login", 307)
+}
+
+type loginer struct {
+	t *template.Template
+}
+
+type loginData struct {
+	Notice string
+}
+
+func (l *loginer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.t.Execute(w, &loginData{})
+}
+
+const (
+	readOnly    = "This API token is read-only"
+	tokenDenied = "API tokens can't be used to manage accounts"
+)
+
+// A failed login or invitation doesn't say why it failed, so that it can't
+// be used to find out who is registered.
+const (
+	badLogin  = "Invalid password or unknown user"
+	badInvite = "This invitation is invalid, or has already been used"
+)
+
+// bearerToken returns the token from the Authorization header of r, if any.
+func bearerToken(r *http.Request) string {
+	const scheme = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(scheme):])
+}
+
+// authenticate finds the player making the request r, using the bearer
+// token if there is one, or the id cookie otherwise. The bearer token may
+// be either an API token or the value of the id cookie.
+func authenticate(g *state.Game, r *http.Request) (string, *state.PlayerInfo) {
+	token := bearerToken(r)
+	if state.IsToken(token) {
+		return g.PlayerByToken(token)
+	}
+	if len(token) < 1 {
+		c, err := r.Cookie("id")
+		if err != nil {
+			return "", nil
+		}
+		token = c.Value
+	}
+	cookie, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", nil
+	}
+	return g.PlayerByCookie(cookie)
+}
+
+// startSession logs the player in, by starting a new session and setting
+// its cookie.
+func startSession(w http.ResponseWriter, r *http.Request, g *state.Game, p *state.PlayerInfo) {
+	cookie := p.NewSession(r.UserAgent(), remoteIP(r))
+	http.SetCookie(w, &http.Cookie{
+		Name:     "id",
+		Value:    base64.RawURLEncoding.EncodeToString(cookie),
+		MaxAge:   int(g.SessionLength().Seconds()),
+		HttpOnly: true,
+	})
+}
+
+// busy reports that the password couldn't be hashed, because the server is
+// already hashing as many as it can.
+func busy(w http.ResponseWriter, t *template.Template, err error) {
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	t.Execute(w, &errorReason{err.Error()})
 }
 
 func thinspForAgent(agent string) string {
@@ -88,43 +167,57 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if len(token) > 0 {
 		// New user
 		if len(name) < 1 || subtle.ConstantTimeCompare([]byte(token), []byte(inviteHash(h.g, name))) != 1 {
-			h.err.Execute(w, &errorReason{"Invalid invitation"})
+			h.err.Execute(w, &errorReason{badInvite})
+			return
+		}
+		// Check the password first, so that the invitation can be used
+		// again with a better one
+		if len(pw) < 2 {
+			h.err.Execute(w, &errorReason{"Please select a longer password"})
 			return
 		}
 		p = h.g.NewPlayer(name)
 		if p == nil {
-			h.err.Execute(w, &errorReason{"You are already registered"})
+			h.err.Execute(w, &errorReason{badInvite})
 			return
 		}
-		if len(pw) < 2 {
-			h.err.Execute(w, &errorReason{"Please select a longer password"})
+		if err := p.SetPassword(pw); err != nil {
+			// Let them accept the invitation again
+			h.g.DeletePlayer(name)
+			busy(w, h.err, err)
 			return
 		}
-		p.SetPassword(pw)
-		cookie := p.NewCookie()
-		http.SetCookie(w, &http.Cookie{Name: "id", Value: base64.RawURLEncoding.EncodeToString(cookie)})
+		startSession(w, r, h.g, p)
 	} else if len(name) > 1 {
 		// User login
-		p = h.g.Player(name)
-		// Don't do this in real code, by calculating the password hash after checking
-		// for the presence of a user, an attacker can test for the presence of a user.
-		if p == nil || !p.CheckPassword(pw) {
-			h.err.Execute(w, &errorReason{"Invalid password or unknown user"})
+		if !allowPassword(r, name) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			h.err.Execute(w, &errorReason{tooMany})
 			return
 		}
-		cookie := p.NewCookie()
-		http.SetCookie(w, &http.Cookie{Name: "id", Value: base64.RawURLEncoding.EncodeToString(cookie)})
-	} else {
-		// Returning user
-		c, err := r.Cookie("id")
+		ip := remoteIP(r)
+		if until, ok := h.g.LoginAllowed(name, ip); !ok {
+			w.WriteHeader(http.StatusTooManyRequests)
+			h.login.Execute(w, &loginData{"Too many failed logins, please try again after " +
+				until.In(h.g.Location()).Format("15:04 MST")})
+			return
+		}
+		var err error
+		p, err = h.g.CheckLogin(name, pw)
 		if err != nil {
-			login(w, r)
+			busy(w, h.err, err)
 			return
 		}
-		cookie, err := base64.RawURLEncoding.DecodeString(c.Value)
-		if err == nil {
-			name, p = h.g.PlayerByCookie(cookie)
+		if p == nil {
+			h.g.LoginFailed(name, ip)
+			h.err.Execute(w, &errorReason{badLogin})
+			return
 		}
+		h.g.LoginSucceeded(name)
+		startSession(w, r, h.g, p)
+	} else {
+		// Returning user
+		name, p = authenticate(h.g, r)
 		if p == nil {
 			login(w, r)
 			return
@@ -132,6 +225,12 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	lotsstr := r.FormValue("lots")
+	cancel := r.FormValue("cancel")
+	withdraw := r.FormValue("withdraw")
+	if (len(lotsstr) > 0 || len(cancel) > 0 || len(withdraw) > 0) && !p.CanTrade() {
+		h.err.Execute(w, &errorReason{readOnly})
+		return
+	}
 	if len(lotsstr) > 0 {
 		lots, err := strconv.ParseUint(lotsstr, 10, 64)
 		if err != nil {
@@ -144,6 +243,26 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			err = p.Buy(r.FormValue("stock"), lots)
 		case "sell":
 			err = p.Sell(r.FormValue("stock"), lots)
+		case "short":
+			err = p.Short(r.FormValue("stock"), lots)
+		case "cover":
+			err = p.Cover(r.FormValue("stock"), lots)
+		case "limitbuy", "limitsell", "stop", "take":
+			var limit, days uint64
+			limit, err = strconv.ParseUint(r.FormValue("limit"), 10, 64)
+			if err == nil {
+				days, err = strconv.ParseUint(r.FormValue("days"), 10, 16)
+			}
+			if err == nil {
+				expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+				err = p.PlaceOrder(r.FormValue("stock"), strings.TrimPrefix(action, "limit"), lots, limit, expires)
+			}
+		case "bid", "ask":
+			var price uint64
+			price, err = strconv.ParseUint(r.FormValue("limit"), 10, 64)
+			if err == nil {
+				_, err = p.PostOffer(r.FormValue("stock"), action, lots, price)
+			}
 		case "":
 		default:
 			h.err.Execute(w, &errorReason{"Unrecognized action: " + action})
@@ -153,6 +272,27 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			h.err.Execute(w, &errorReason{err.Error()})
 			return
 		}
+		h.g.Notify("trade")
+	}
+	if len(cancel) > 0 {
+		id, err := strconv.ParseInt(cancel, 10, 64)
+		if err == nil {
+			err = p.CancelOrder(id)
+		}
+		if err != nil {
+			h.err.Execute(w, &errorReason{err.Error()})
+			return
+		}
+	}
+	if len(withdraw) > 0 {
+		id, err := strconv.ParseInt(withdraw, 10, 64)
+		if err == nil {
+			err = p.WithdrawOffer(id)
+		}
+		if err != nil {
+			h.err.Execute(w, &errorReason{err.Error()})
+			return
+		}
 	}
 	thinsp := thinspForAgent(r.UserAgent()) // USA uses "," instead of "&thinsp;"
 
@@ -160,6 +300,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Name   string
 		Cost   uint64
 		Shares uint64
+		Short  uint64
 		Value  template.HTML
 	}
 	type data struct {
@@ -169,22 +310,45 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		NetWorth template.HTML
 		News     []string
 		Leader   []formattedInfo
+		Orders   []state.Order
+		Offers   []state.Offer
 		Invite   bool
 	}
 	s := h.g.ListStocks()
 	d := &data{Name: name, News: h.g.News(), Leader: formatInfo(h.g.Leaders(), thinsp)}
 	d.Invite = p.IsAdmin()
+	loc := h.g.Location()
+	d.Orders = p.Orders()
+	for k := range d.Orders {
+		d.Orders[k].Expires = d.Orders[k].Expires.In(loc)
+	}
+	d.Offers = p.Offers()
+	for k := range d.Offers {
+		d.Offers[k].Created = d.Offers[k].Created.In(loc)
+	}
 	ph := p.Holdings()
 	nw := ph.Cash
-	for k, v := range s {
+	var owed uint64
+	for _, v := range s {
+		shares, short := ph.Shares[v.Name], ph.Short[v.Name]
+		value := "$" + formatValue(shares*v.Value-short*v.Value, thinsp)
+		if short > shares {
+			value = "-$" + formatValue((short-shares)*v.Value, thinsp)
+		}
 		d.Stocks = append(d.Stocks, entry{
 			Name:   v.Name,
 			Cost:   v.Value,
-			Shares: ph.Shares[k],
-			Value:  formatValue(ph.Shares[k]*v.Value, thinsp),
+			Shares: shares,
+			Short:  short,
+			Value:  value,
 		})
-		nw += ph.Shares[k] * v.Value
+		nw += shares * v.Value
+		owed += short * v.Value
+	}
+	if owed > nw {
+		owed = nw
 	}
+	nw -= owed
 	d.Cash = formatValue(ph.Cash, thinsp)
 	d.NetWorth = formatValue(nw, thinsp)
 	h.t.Execute(w, d)
@@ -200,11 +364,11 @@ func (i *inviter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	token := r.FormValue("i")
 	if len(name) < 1 || subtle.ConstantTimeCompare([]byte(token), []byte(inviteHash(i.g, name))) != 1 {
-		i.err.Execute(w, &errorReason{"Invalid invitation"})
+		i.err.Execute(w, &errorReason{badInvite})
 		return
 	}
 	if i.g.HasPlayer(name) {
-		i.err.Execute(w, &errorReason{"You are already registered"})
+		i.err.Execute(w, &errorReason{badInvite})
 		return
 	}
 
@@ -227,19 +391,13 @@ type newer struct {
 }
 
 func (n *newer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := r.Cookie("id")
-	if err != nil {
-		login(w, r)
-		return
-	}
-	cookie, err := base64.RawURLEncoding.DecodeString(c.Value)
-	if err != nil {
+	name, p := authenticate(n.g, r)
+	if len(name) < 1 || p == nil {
 		login(w, r)
 		return
 	}
-	name, p := n.g.PlayerByCookie(cookie)
-	if len(name) < 1 || p == nil {
-		login(w, r)
+	if p.ViaToken() {
+		n.err.Execute(w, &errorReason{tokenDenied})
 		return
 	}
 	if !p.IsAdmin() {
@@ -265,6 +423,10 @@ func (n *newer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	n.t.Execute(w, &d)
 }
 
+// auditEntries is how many of the latest audit log entries the admin console
+// shows.
+const auditEntries = 20
+
 type adminer struct {
 	t   *template.Template
 	err *template.Template
@@ -272,19 +434,13 @@ type adminer struct {
 }
 
 func (a *adminer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := r.Cookie("id")
-	if err != nil {
-		login(w, r)
-		return
-	}
-	cookie, err := base64.RawURLEncoding.DecodeString(c.Value)
-	if err != nil {
+	name, p := authenticate(a.g, r)
+	if len(name) < 1 || p == nil {
 		login(w, r)
 		return
 	}
-	name, p := a.g.PlayerByCookie(cookie)
-	if len(name) < 1 || p == nil {
-		login(w, r)
+	if p.ViaToken() {
+		a.err.Execute(w, &errorReason{tokenDenied})
 		return
 	}
 	if !p.IsAdmin() {
@@ -292,6 +448,7 @@ func (a *adminer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	admin := name
 	name = r.FormValue("delete")
 	if len(name) >= 2 {
 		var list = []struct {
@@ -315,10 +472,105 @@ func (a *adminer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if r.FormValue("rules") == "yes" {
+		rules := make(map[string]string)
+		for _, v := range a.g.RuleList() {
+			value := r.FormValue(v.Name)
+			if len(value) < 1 || value == strconv.FormatUint(v.Value, 10) {
+				continue
+			}
+			rules[v.Name] = value
+		}
+		if len(rules) > 0 {
+			if err := a.g.SetRules(rules); err != nil {
+				a.err.Execute(w, &errorReason{err.Error()})
+				return
+			}
+		}
+		if tz := r.FormValue("timezone"); len(tz) > 0 && tz != a.g.TimeZone() {
+			if err := a.g.SetTimeZone(tz); err != nil {
+				a.err.Execute(w, &errorReason{err.Error()})
+				return
+			}
+		}
+		if sched := r.FormValue("schedule"); len(sched) > 0 && sched != a.g.Schedule() {
+			if err := a.g.SetSchedule(sched); err != nil {
+				a.err.Execute(w, &errorReason{err.Error()})
+				return
+			}
+		}
+		if length := r.FormValue("seasonlength"); len(length) > 0 && length != a.g.SeasonLength() {
+			if err := a.g.SetSeasonLength(length); err != nil {
+				a.err.Execute(w, &errorReason{err.Error()})
+				return
+			}
+		}
+	}
+
+	var err error
+	switch r.FormValue("season") {
+	case "extend":
+		days, perr := strconv.ParseUint(r.FormValue("days"), 10, 16)
+		if perr != nil || days < 1 {
+			err = errors.New("Please enter the number of days to extend the season by")
+		} else {
+			err = a.g.ExtendSeason(time.Duration(days) * 24 * time.Hour)
+		}
+	case "end":
+		if r.FormValue("sure") != "yes" {
+			err = errors.New("You aren't sure")
+		} else {
+			a.g.EndSeason()
+		}
+	case "add":
+		loc := a.g.Location()
+		start, serr := time.ParseInLocation("2006-01-02", r.FormValue("start"), loc)
+		end, eerr := time.ParseInLocation("2006-01-02", r.FormValue("end"), loc)
+		if serr != nil || eerr != nil {
+			err = errors.New("Please enter the first and last days of the season")
+		} else {
+			// The season includes its last day
+			err = a.g.AddSeasonDates(start, end.AddDate(0, 0, 1))
+		}
+	case "delete":
+		id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		err = a.g.DeleteSeasonDates(id)
+	}
+	if kind, subject, ok := strings.Cut(r.FormValue("unlock"), ":"); ok && err == nil {
+		err = a.g.Unlock(kind, subject, admin)
+	}
+	if err != nil {
+		a.err.Execute(w, &errorReason{err.Error()})
+		return
+	}
+
 	var d struct {
-		Players []state.LeaderInfo
+		Players      []state.LeaderInfo
+		Rules        []state.Rule
+		Schedule     string
+		TimeZone     string
+		SeasonLength string
+		Season       state.SeasonDates
+		SeasonDates  []state.SeasonDates
+		Lockouts     []state.Lockout
+		Audit        []state.AuditEntry
 	}
 	d.Players = a.g.Leaders()
+	d.Rules = a.g.RuleList()
+	d.Schedule = a.g.Schedule()
+	d.TimeZone = a.g.TimeZone()
+	d.SeasonLength = a.g.SeasonLength()
+	d.Season = a.g.CurrentSeason()
+	d.SeasonDates = a.g.SeasonDates()
+	loc := a.g.Location()
+	d.Lockouts = a.g.Lockouts()
+	for k := range d.Lockouts {
+		d.Lockouts[k].Until = d.Lockouts[k].Until.In(loc)
+	}
+	d.Audit = a.g.AuditLog(auditEntries)
+	for k := range d.Audit {
+		d.Audit[k].Date = d.Audit[k].Date.In(loc)
+	}
 	a.t.Execute(w, &d)
 }
 
@@ -329,19 +581,13 @@ type newpwer struct {
 }
 
 func (np *newpwer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := r.Cookie("id")
-	if err != nil {
+	name, p := authenticate(np.g, r)
+	if len(name) < 1 || p == nil {
 		login(w, r)
 		return
 	}
-	cookie, err := base64.RawURLEncoding.DecodeString(c.Value)
-	if err != nil {
-		login(w, r)
-		return
-	}
-	name, p := np.g.PlayerByCookie(cookie)
-	if len(name) < 1 || p == nil {
-		login(w, r)
+	if p.ViaToken() {
+		np.err.Execute(w, &errorReason{tokenDenied})
 		return
 	}
 
@@ -353,8 +599,18 @@ func (np *newpwer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	pw := r.FormValue("pw")
 	if len(pw) > 1 {
 		// Password Change
+		if !allowPassword(r, name) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			np.err.Execute(w, &errorReason{tooMany})
+			return
+		}
 		old := r.FormValue("oldpw")
-		if !p.CheckPassword(old) {
+		ok, err := p.CheckPassword(old)
+		if err != nil {
+			busy(w, np.err, err)
+			return
+		}
+		if !ok {
 			np.err.Execute(w, &errorReason{"Invalid password"})
 			return
 		}
@@ -363,7 +619,10 @@ func (np *newpwer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			np.err.Execute(w, &errorReason{"New passwords do not match"})
 			return
 		}
-		p.SetPassword(pw)
+		if err = p.SetPassword(pw); err != nil {
+			busy(w, np.err, err)
+			return
+		}
 		d.Success = true
 	}
 
@@ -371,6 +630,154 @@ func (np *newpwer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	np.t.Execute(w, &d)
 }
 
+type tokener struct {
+	t   *template.Template
+	err *template.Template
+	g   *state.Game
+}
+
+func (t *tokener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := authenticate(t.g, r)
+	if len(name) < 1 || p == nil {
+		login(w, r)
+		return
+	}
+	if p.ViaToken() {
+		t.err.Execute(w, &errorReason{tokenDenied})
+		return
+	}
+
+	var d struct {
+		Name     string
+		NewToken string
+		Tokens   []state.Token
+	}
+
+	if tname := r.FormValue("token"); len(tname) > 0 {
+		token, err := p.NewToken(tname, r.FormValue("scope"))
+		if err != nil {
+			t.err.Execute(w, &errorReason{err.Error()})
+			return
+		}
+		d.NewToken = token
+	}
+	if revoke := r.FormValue("revoke"); len(revoke) > 0 {
+		id, err := strconv.ParseInt(revoke, 10, 64)
+		if err == nil {
+			err = p.RevokeToken(id)
+		}
+		if err != nil {
+			t.err.Execute(w, &errorReason{err.Error()})
+			return
+		}
+	}
+
+	d.Name = name
+	d.Tokens = p.Tokens()
+	t.t.Execute(w, &d)
+}
+
+type sessioner struct {
+	t   *template.Template
+	err *template.Template
+	g   *state.Game
+}
+
+func (s *sessioner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := authenticate(s.g, r)
+	if len(name) < 1 || p == nil {
+		login(w, r)
+		return
+	}
+	if p.ViaToken() {
+		s.err.Execute(w, &errorReason{tokenDenied})
+		return
+	}
+
+	if revoke := r.FormValue("revoke"); len(revoke) > 0 {
+		id, err := strconv.ParseInt(revoke, 10, 64)
+		if err == nil {
+			err = p.EndSession(id)
+		}
+		if err != nil {
+			s.err.Execute(w, &errorReason{err.Error()})
+			return
+		}
+	}
+
+	var d struct {
+		Name     string
+		Sessions []state.Session
+	}
+	d.Name = name
+	d.Sessions = p.Sessions()
+	s.t.Execute(w, &d)
+}
+
+type ledgerer struct {
+	t   *template.Template
+	err *template.Template
+	g   *state.Game
+}
+
+func (l *ledgerer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := authenticate(l.g, r)
+	if len(name) < 1 || p == nil {
+		login(w, r)
+		return
+	}
+
+	// The administrator can investigate disputes by viewing the
+	// transactions of any player.
+	if other := r.FormValue("player"); len(other) > 0 && other != name {
+		if !p.IsAdmin() {
+			l.err.Execute(w, &errorReason{"Only the administrator can view other players' transactions"})
+			return
+		}
+		name, p = other, l.g.Player(other)
+	}
+	var ledger []state.LedgerEntry
+	if p != nil {
+		ledger = p.Ledger()
+	} else if ledger = l.g.DeletedLedger(name); len(ledger) < 1 {
+		l.err.Execute(w, &errorReason{name + " is not a registered player"})
+		return
+	}
+
+	type entry struct {
+		Date   string
+		Stock  string
+		Reason string
+		Shares int64
+		Price  uint64
+		Cash   template.HTML
+	}
+	var d struct {
+		Name    string
+		Entries []entry
+	}
+	d.Name = name
+	thinsp := thinspForAgent(r.UserAgent())
+	for _, v := range ledger {
+		cash := "$" + formatValue(uint64(v.Cash), thinsp)
+		if v.Cash < 0 {
+			cash = "-$" + formatValue(uint64(-v.Cash), thinsp)
+		}
+		d.Entries = append(d.Entries, entry{
+			Date:   v.Date.Format("2006-01-02 15:04"),
+			Stock:  v.Stock,
+			Reason: v.Reason,
+			Shares: v.Shares,
+			Price:  v.Price,
+			Cash:   cash,
+		})
+	}
+	l.t.Execute(w, &d)
+}
+
+// calendarSeasons is how many upcoming seasons the history page shows.
+const calendarSeasons = 5
+
 type historian struct {
 	t *template.Template
 	g *state.Game
@@ -378,26 +785,110 @@ type historian struct {
 
 func (h *historian) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var d struct {
-		History []string
+		History  []state.HistoryItem
+		Calendar []state.SeasonDates
+		Seasons  []state.SeasonSummary
 	}
 	d.History = h.g.History()
-	if len(d.History) < 1 {
-		d.History = []string{"This game is too young to have a history"}
-	}
+	d.Calendar = h.g.SeasonCalendar(calendarSeasons)
+	d.Seasons = h.g.Seasons()
 	h.t.Execute(w, &d)
 }
 
+// famer shows the hall of fame: how every player has done over all of the
+// seasons that have ended.
+type famer struct {
+	t *template.Template
+	g *state.Game
+}
+
+func (f *famer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var d struct {
+		Players []state.PlayerStats
+	}
+	d.Players = f.g.HallOfFame()
+	f.t.Execute(w, &d)
+}
+
+// seasoner shows the final standings of a season that has ended.
+type seasoner struct {
+	t   *template.Template
+	err *template.Template
+	g   *state.Game
+}
+
+func (s *seasoner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.FormValue("n"))
+	d := s.g.SeasonArchive(n)
+	if d == nil {
+		w.WriteHeader(http.StatusNotFound)
+		s.err.Execute(w, &errorReason{"That season hasn't ended yet"})
+		return
+	}
+	s.t.Execute(w, d)
+}
+
+type abouter struct {
+	t *template.Template
+	g *state.Game
+}
+
+func (a *abouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := struct {
+		state.Rules
+		Schedule string
+		TimeZone string
+		Seasons  string
+	}{a.g.Rules(), a.g.Schedule(), a.g.TimeZone(), a.g.DescribeSeasonLength()}
+	a.t.Execute(w, &d)
+}
+
+type stocker struct {
+	t *template.Template
+	g *state.Game
+}
+
+func (s *stocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type season struct {
+		Season  int
+		Current bool
+		Chart   template.HTML
+	}
+	var d struct {
+		Name    string
+		Value   uint64
+		Seasons []season
+		Bids    []state.DepthLevel
+		Asks    []state.DepthLevel
+	}
+	d.Name = r.FormValue("name")
+	for _, v := range s.g.ListStocks() {
+		if v.Name == d.Name {
+			d.Value = v.Value
+		}
+	}
+	d.Bids, d.Asks = s.g.Depth(d.Name)
+
+	current := s.g.Season()
+	bySeason := make(map[int][]state.PricePoint)
+	for _, v := range s.g.PriceHistory(d.Name) {
+		bySeason[v.Season] = append(bySeason[v.Season], v)
+	}
+	for i := current; i > 0; i-- {
+		if pp, ok := bySeason[i]; ok {
+			d.Seasons = append(d.Seasons, season{Season: i, Current: i == current, Chart: priceChart(pp)})
+		}
+	}
+	s.t.Execute(w, &d)
+}
+
 type logouter struct {
 	g *state.Game
 }
 
 func (l *logouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if c, err := r.Cookie("id"); err == nil {
-		if cookie, err := base64.RawURLEncoding.DecodeString(c.Value); err == nil {
-			if _, p := l.g.PlayerByCookie(cookie); p != nil {
-				p.ClearCookie()
-			}
-		}
+	if _, p := authenticate(l.g, r); p != nil && !p.ViaToken() {
+		p.Logout()
 	}
 	http.SetCookie(w, &http.Cookie{Name: "id", Value: ""})
 	login(w, r)
@@ -432,6 +923,21 @@ func start() {
 		log.Fatal("Fatal Error: ", err)
 	}
 
+	tokensTemplate, err := template.ParseFS(fsroot, path.Join("templates", "tokens.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
+	sessionsTemplate, err := template.ParseFS(fsroot, path.Join("templates", "sessions.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
+	aboutTemplate, err := template.ParseFS(fsroot, path.Join("templates", "about.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
 	errorTemplate, err := template.ParseFS(fsroot, path.Join("templates", "error.html"))
 	if err != nil {
 		log.Fatal("Fatal Error: ", err)
@@ -447,6 +953,29 @@ func start() {
 		log.Fatal("Fatal Error: ", err)
 	}
 
+	fameTemplate, err := template.ParseFS(fsroot, path.Join("templates", "halloffame.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+	seasonTemplate, err := template.ParseFS(fsroot, path.Join("templates", "season.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+	stockTemplate, err := template.ParseFS(fsroot, path.Join("templates", "stock.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
+	ledgerTemplate, err := template.ParseFS(fsroot, path.Join("templates", "ledger.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
+	loginTemplate, err := template.ParseFS(fsroot, path.Join("templates", "login.html"))
+	if err != nil {
+		log.Fatal("Fatal Error: ", err)
+	}
+
 	staticfs, err := fs.Sub(fsroot, "static")
 	if err != nil {
 		log.Fatal("Fatal error opening static/: ", err)
@@ -460,13 +989,23 @@ func start() {
 		return
 	}
 	game.Run()
-	http.Handle("/", &handler{gameTemplate, errorTemplate, game})
+	http.Handle("/", &handler{gameTemplate, errorTemplate, loginTemplate, game})
+	http.Handle("/login", &loginer{loginTemplate})
 	http.Handle("/invite", &inviter{inviteTemplate, errorTemplate, game})
 	http.Handle("/newinvite", &newer{newTemplate, errorTemplate, game})
 	http.Handle("/admin", &adminer{adminTemplate, errorTemplate, game})
 	http.Handle("/newpw", &newpwer{newpwTemplate, errorTemplate, game})
+	http.Handle("/tokens", &tokener{tokensTemplate, errorTemplate, game})
+	http.Handle("/sessions", &sessioner{sessionsTemplate, errorTemplate, game})
+	http.Handle("/ledger", &ledgerer{ledgerTemplate, errorTemplate, game})
 	http.Handle("/history", &historian{historyTemplate, game})
+	http.Handle("/season", &seasoner{seasonTemplate, errorTemplate, game})
+	http.Handle("/halloffame", &famer{fameTemplate, game})
+	http.Handle("/about", &abouter{aboutTemplate, game})
+	http.Handle("/stock", &stocker{stockTemplate, game})
 	http.Handle("/logout", &logouter{game})
+	http.Handle(apiPrefix, &api{game})
+	http.Handle("/events", &eventer{game})
 
 	log.Println("comprod started")
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterh/comprod2/state"
+)
+
+// testHandler creates a game in a temporary file, and the handler for its
+// pages.
+func testHandler(t *testing.T) *handler {
+	t.Helper()
+	var tmpl [3]*template.Template
+	for k, v := range []string{"game.html", "error.html", "login.html"} {
+		var err error
+		if tmpl[k], err = template.ParseFS(fsbuiltin, path.Join("templates", v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	game := state.Create(filepath.Join(t.TempDir(), "game.db"))
+	if game == nil {
+		t.Fatal("Unable to create the game")
+	}
+	t.Cleanup(game.Close)
+	return &handler{tmpl[0], tmpl[1], tmpl[2], game}
+}
+
+func TestGameTimes(t *testing.T) {
+	h := testHandler(t)
+	if err := h.g.SetTimeZone("Asia/Tokyo"); err != nil {
+		t.Skip(err)
+	}
+	p := h.g.NewPlayer("alice")
+	stock := h.g.ListStocks()[0]
+	expires := time.Date(2030, 1, 2, 20, 0, 0, 0, time.UTC)
+	if err := p.PlaceOrder(stock.Name, "buy", 1, stock.Value, expires); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.PostOffer(stock.Name, "bid", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "id", Value: base64.RawURLEncoding.EncodeToString(p.NewSession("test", "127.0.0.1"))})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	body := w.Body.String()
+	if !strings.Contains(body, "Jan 3 05:00") {
+		t.Error("The order's expiry isn't in the game's time zone")
+	}
+	posted := p.Offers()[0].Created.In(h.g.Location()).Format("Jan 2 15:04")
+	if !strings.Contains(body, posted) {
+		t.Error("The offer's time isn't in the game's time zone")
+	}
+	if strings.Contains(body, "UTC") {
+		t.Error("The game page shows a time in UTC")
+	}
+}
+
+func TestInviteShortPassword(t *testing.T) {
+	h := testHandler(t)
+	accept := func(pw string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {"carol"}, "i": {inviteHash(h.g, "carol")}, "pw": {pw}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := accept("x"); !strings.Contains(w.Body.String(), "longer password") {
+		t.Fatalf("A short password was accepted: %s", w.Body.String())
+	}
+	if h.g.HasPlayer("carol") {
+		t.Error("A short password left carol registered")
+	}
+	w := accept("secret")
+	if len(w.Result().Cookies()) != 1 {
+		t.Fatalf("The invitation couldn't be used again: %s", w.Body.String())
+	}
+	if _, err := h.g.CheckLogin("carol", "secret"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func config() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	name := flag.Arg(1)
+	rules := game.RuleList()
+	width := 0
+	for _, v := range rules {
+		if len(v.Name) > width {
+			width = len(v.Name)
+		}
+	}
+	for _, v := range rules {
+		if len(name) < 1 {
+			fmt.Printf("%-*s %10d  %s\n", width, v.Name, v.Value, v.Description)
+		} else if v.Name == name && len(flag.Arg(2)) < 1 {
+			fmt.Println(v.Name, v.Value)
+			return
+		}
+	}
+	if len(name) < 1 {
+		return
+	}
+	if err := game.SetRule(name, flag.Arg(2)); err != nil {
+		fmt.Println(err)
+	}
+}
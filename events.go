@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/peterh/comprod2/state"
+)
+
+// eventer streams the game to a logged in player as server-sent events.
+// An event is sent when the stream starts, after every turn, and after
+// every trade, each holding a complete snapshot of the player's view of
+// the game.
+type eventer struct {
+	g *state.Game
+}
+
+type eventSnapshot struct {
+	Kind      string        `json:"kind"`
+	News      []string      `json:"news"`
+	Leaders   []apiLeader   `json:"leaders"`
+	Portfolio *apiPortfolio `json:"portfolio"`
+}
+
+// keepAlive is how often a comment is sent to an idle stream, so that
+// proxies don't close it.
+const keepAlive = 30 * time.Second
+
+func (e *eventer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := authenticate(e.g, r)
+	if p == nil {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Please log in, or supply a bearer token")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "unsupported", "Streaming is not supported")
+		return
+	}
+
+	events, stop := e.g.Listen()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(kind string) {
+		snap := eventSnapshot{
+			Kind:      kind,
+			News:      append([]string{}, e.g.News()...),
+			Leaders:   leaders(e.g),
+			Portfolio: portfolio(e.g, name, p),
+		}
+		data, _ := json.Marshal(&snap)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	send("hello")
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+	for {
+		select {
+		case ev := <-events:
+			// Stop streaming if the player logged out in the meantime
+			if _, p = authenticate(e.g, r); p == nil {
+				return
+			}
+			send(ev.Kind)
+		case <-tick.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
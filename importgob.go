@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func importGob() {
+	file := flag.Arg(1)
+	if len(file) < 1 {
+		flag.Usage()
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer f.Close()
+	if err = state.ImportGob(*data, f); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Imported", file, "into", *data)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoginTiming logs in with wrong passwords, alternating between
+// registered and unknown names, and checks that the failures can't be told
+// apart, either by their responses or by their median times.
+func TestLoginTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Hashes too many passwords for a short test")
+	}
+	const (
+		n         = 40 // Logins with each kind of name
+		tolerance = 10 // Largest allowed difference between the median times, in percent
+	)
+
+	h := testHandler(t)
+	game := h.g
+
+	for i := 0; i < n; i++ {
+		if err := game.NewPlayer(fmt.Sprintf("known%d", i)).SetPassword("secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each name is only tried once, from its own address, so that no rate
+	// limit or lockout applies
+	attempt := 0
+	try := func(name string) (time.Duration, string) {
+		attempt++
+		form := url.Values{"name": {name}, "pw": {"wrong"}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:1234", attempt>>16&0xff, attempt>>8&0xff, attempt&0xff)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		return time.Since(start), fmt.Sprintf("%d %s", w.Code, w.Body.String())
+	}
+	// Warm up, so that the first hashes don't count against either kind
+	for i := 0; i < 3; i++ {
+		try(fmt.Sprintf("warmup%d", i))
+	}
+
+	kinds := []string{"known", "unknown"}
+	times := make(map[string][]time.Duration)
+	responses := make(map[string]string)
+	for i := 0; i < n; i++ {
+		for _, k := range rand.Perm(len(kinds)) {
+			kind := kinds[k]
+			d, response := try(fmt.Sprintf("%s%d", kind, i))
+			times[kind] = append(times[kind], d)
+			if prev, ok := responses[kind]; ok && prev != response {
+				t.Errorf("The responses to %s names vary", kind)
+			}
+			responses[kind] = response
+		}
+	}
+	if responses["known"] != responses["unknown"] {
+		t.Error("The responses to known and unknown names differ")
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for _, kind := range kinds {
+		d := times[kind]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		t.Logf("%-8s min %.1fms, quartile %.1fms, median %.1fms, quartile %.1fms, max %.1fms", kind,
+			ms(d[0]), ms(d[len(d)/4]), ms(d[len(d)/2]), ms(d[len(d)*3/4]), ms(d[len(d)-1]))
+	}
+	known, unknown := times["known"][n/2], times["unknown"][n/2]
+	diff := ms(unknown-known) / ms(known) * 100
+	if diff > tolerance || diff < -tolerance {
+		t.Errorf("The median for unknown names differs from known names by %+.1f%%, more than %d%%", diff, tolerance)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func migrate() {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Dry run: try the migrations, then roll them back")
+	fs.Parse(flag.Args()[1:])
+
+	version, pending, err := state.PendingMigrations(*data)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Schema version %d (latest is %d)\n", version, state.LatestSchema())
+	if len(pending) < 1 {
+		fmt.Println("The game is up to date")
+		return
+	}
+	for _, m := range pending {
+		fmt.Printf("  %04d %s\n", m.Version, m.Name)
+	}
+	backup, err := state.Migrate(*data, *dryRun)
+	switch {
+	case err != nil:
+		fmt.Println(err)
+	case *dryRun:
+		fmt.Println("The migrations succeeded, and were rolled back")
+	default:
+		if len(backup) > 0 {
+			fmt.Println("Saved a backup to", backup)
+		}
+		fmt.Println("Upgraded to schema version", state.LatestSchema())
+	}
+}
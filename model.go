@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func model() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	name := flag.Arg(1)
+	if len(name) < 1 {
+		fmt.Println("Market model:", game.MarketModel())
+		fmt.Println("Available models:", strings.Join(state.MarketModels(), ", "))
+		return
+	}
+	if err := game.SetMarketModel(name); err != nil {
+		fmt.Println(err)
+	}
+}
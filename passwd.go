@@ -32,5 +32,7 @@ func passwd() {
 		fmt.Println(errmsg, user)
 		return
 	}
-	p.SetPassword(password)
+	if err := p.SetPassword(password); err != nil {
+		fmt.Println(err)
+	}
 }
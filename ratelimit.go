@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimit is a token bucket for each key (an IP address, or a player's
+// name). Each attempt takes a token, and tokens come back at a steady rate,
+// up to burst.
+type rateLimit struct {
+	mu      sync.Mutex
+	every   time.Duration
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxBuckets is how many keys a rateLimit tracks before it forgets the ones
+// that have refilled.
+const maxBuckets = 10000
+
+func newRateLimit(every time.Duration, burst int) *rateLimit {
+	return &rateLimit{every: every, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token for key, and reports whether there was one to take.
+func (rl *rateLimit) allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if len(rl.buckets) >= maxBuckets {
+		for k, v := range rl.buckets {
+			if rl.refill(v, now) >= rl.burst {
+				delete(rl.buckets, k)
+			}
+		}
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = rl.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (rl *rateLimit) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(rl.every)
+	if tokens > rl.burst {
+		tokens = rl.burst
+	}
+	return tokens
+}
+
+// Password checks are limited both from each address, so that one client
+// can't use up the server's capacity to hash passwords, and for each
+// account, so that a password can't be guessed from many addresses.
+var (
+	passwordsByIP      = newRateLimit(6*time.Second, 20)
+	passwordsByAccount = newRateLimit(30*time.Second, 10)
+)
+
+const tooMany = "Too many password attempts, please wait a minute and try again"
+
+// allowPassword reports whether the request may check a password for the
+// named player.
+func allowPassword(r *http.Request, name string) bool {
+	// Check both, so that every attempt counts against both limits
+	ip := passwordsByIP.allow(remoteIP(r))
+	account := passwordsByAccount.allow(name)
+	return ip && account
+}
+
+// remoteIP returns the address of the client that made r.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ip
+}
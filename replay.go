@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func replay() {
+	date := flag.Arg(1)
+	if len(date) < 1 {
+		flag.Usage()
+		return
+	}
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	turns, err := game.Replay(date)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, t := range turns {
+		fmt.Printf("Turn %s (seed %d, %s market, %d of %d rounds)\n", t.Date.Format("2006-01-02 15:04:05"), t.Seed, t.Model, t.Rounds, t.Rules.Rounds)
+		for _, n := range t.News {
+			fmt.Println("  ", n)
+		}
+		for k, v := range t.Close {
+			status := "matches"
+			if k >= len(t.Recorded) || t.Recorded[k] != v {
+				status = "DIFFERS from recorded"
+			}
+			fmt.Printf("   %-12s $%-4d -> $%-4d %s\n", v.Name, t.Open[k].Value, v.Value, status)
+		}
+	}
+}
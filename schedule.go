@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func schedule() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	if flag.NArg() < 2 {
+		fmt.Println("Schedule:", game.Schedule())
+		return
+	}
+	if err := game.SetSchedule(strings.Join(flag.Args()[1:], " ")); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func season() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	switch flag.Arg(1) {
+	case "":
+		fmt.Println("Season length:", game.SeasonLength())
+		for _, v := range game.SeasonCalendar(calendarSeasons) {
+			end := "no end yet"
+			if !v.End.IsZero() {
+				end = v.End.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("Season %d: %s to %s\n", v.Number, v.Start.Format("2006-01-02 15:04"), end)
+		}
+		if dates := game.SeasonDates(); len(dates) > 0 {
+			fmt.Println("Season calendar:")
+			for _, v := range dates {
+				fmt.Printf("  %d: %s to %s\n", v.ID, v.Start.Format("2006-01-02"), v.LastDay().Format("2006-01-02"))
+			}
+		}
+	case "length":
+		if err := game.SetSeasonLength(strings.Join(flag.Args()[2:], " ")); err != nil {
+			fmt.Println(err)
+		}
+	case "add":
+		loc := game.Location()
+		start, serr := time.ParseInLocation("2006-01-02", flag.Arg(2), loc)
+		end, eerr := time.ParseInLocation("2006-01-02", flag.Arg(3), loc)
+		if serr != nil || eerr != nil {
+			fmt.Println("The first and last days of the season must look like 2006-01-02")
+			return
+		}
+		// The season includes its last day
+		if err := game.AddSeasonDates(start, end.AddDate(0, 0, 1)); err != nil {
+			fmt.Println(err)
+		}
+	case "remove":
+		id, err := strconv.ParseInt(flag.Arg(2), 10, 64)
+		if err == nil {
+			err = game.DeleteSeasonDates(id)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	case "extend":
+		days, err := strconv.ParseUint(flag.Arg(2), 10, 16)
+		if err == nil {
+			err = game.ExtendSeason(time.Duration(days) * 24 * time.Hour)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	case "end":
+		game.EndSeason()
+	default:
+		flag.Usage()
+	}
+}
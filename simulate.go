@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/peterh/comprod2/state"
+)
+
+// A strategy trades on behalf of a simulated player before each turn.
+// prev holds the prices before the previous turn (nil on the first turn
+// of a season).
+type strategy struct {
+	name  string
+	trade func(p *state.PlayerInfo, stocks, prev []state.Stock, rng *rand.Rand)
+}
+
+var strategies = []strategy{
+	{"buy-and-hold", buyAndHold},
+	{"always-cash", func(*state.PlayerInfo, []state.Stock, []state.Stock, *rand.Rand) {}},
+	{"momentum", momentum},
+	{"random", randomTrader},
+}
+
+// lot is the lot size of the simulated game
+var lot uint64
+
+func sellAll(p *state.PlayerInfo, stocks []state.Stock) {
+	h := p.Holdings()
+	for _, v := range stocks {
+		if h.Shares[v.Name] >= lot {
+			p.Sell(v.Name, h.Shares[v.Name]/lot)
+		}
+	}
+}
+
+func buyAndHold(p *state.PlayerInfo, stocks, prev []state.Stock, rng *rand.Rand) {
+	if prev != nil {
+		return
+	}
+	cash := p.Holdings().Cash / uint64(len(stocks))
+	for _, v := range stocks {
+		p.Buy(v.Name, cash/(v.Value*lot))
+	}
+}
+
+func momentum(p *state.PlayerInfo, stocks, prev []state.Stock, rng *rand.Rand) {
+	if prev == nil {
+		return
+	}
+	best, rise := -1, 0.0
+	for k, v := range stocks {
+		if v.Name != prev[k].Name {
+			continue
+		}
+		if r := float64(v.Value) / float64(prev[k].Value); r > rise {
+			best, rise = k, r
+		}
+	}
+	sellAll(p, stocks)
+	if best >= 0 && rise > 1 {
+		p.Buy(stocks[best].Name, p.Holdings().Cash/(stocks[best].Value*lot))
+	}
+}
+
+func randomTrader(p *state.PlayerInfo, stocks, prev []state.Stock, rng *rand.Rand) {
+	k := rng.Intn(len(stocks))
+	h := p.Holdings()
+	if rng.Intn(2) == 0 {
+		if h.Shares[stocks[k].Name] >= lot {
+			p.Sell(stocks[k].Name, uint64(rng.Int63n(int64(h.Shares[stocks[k].Name]/lot)))+1)
+		}
+		return
+	}
+	if lots := h.Cash / (stocks[k].Value * lot); lots > 0 {
+		p.Buy(stocks[k].Name, uint64(rng.Int63n(int64(lots)))+1)
+	}
+}
+
+type stockStats struct {
+	days, bankrupt, splits int
+	dividends, value       uint64
+}
+
+func simulate() {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	days := fs.Int("days", 27, "Number of turns in each season (at most 27)")
+	seasons := fs.Int("seasons", 12, "Number of seasons to simulate")
+	players := fs.Int("players", 1, "Number of players using each strategy")
+	model := fs.String("model", "", "Market model (default: the game's default)")
+	seed := fs.Int64("seed", 0, "Random number seed (default: random)")
+	fs.Parse(flag.Args()[1:])
+	if *days < 1 || *days > 27 || *seasons < 1 || *players < 1 {
+		fs.Usage()
+		return
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	game := state.Create(fmt.Sprintf("file:simulate%d?mode=memory&cache=shared", os.Getpid()))
+	if game == nil {
+		fmt.Println("Unable to create simulated game")
+		return
+	}
+	defer game.Close()
+	game.SetSeed(*seed)
+	if len(*model) > 0 {
+		if err := game.SetMarketModel(*model); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	lot = game.Rules().LotSize
+
+	type trader struct {
+		s *strategy
+		p *state.PlayerInfo
+	}
+	var traders []trader
+	strategyOf := make(map[string]string)
+	for k := range strategies {
+		for i := 0; i < *players; i++ {
+			name := fmt.Sprintf("%s %d", strategies[k].name, i+1)
+			traders = append(traders, trader{&strategies[k], game.NewPlayer(name)})
+			strategyOf[name] = strategies[k].name
+		}
+	}
+
+	// Each season is a calendar month. The first turn of each month closes
+	// the previous season, so a season's turns are on days 2 through days+1,
+	// and its final standings are taken after the last of those.
+	stats := make(map[string]*stockStats)
+	worth := make(map[string][]uint64)
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	game.SetTimeZone("UTC")
+	game.SetSeasonLength("monthly")
+	game.StartSeason(start)
+	game.Advance(start)
+	for s := 0; s < *seasons; s++ {
+		var prev []state.Stock
+		for d := 0; d < *days; d++ {
+			stocks := game.ListStocks()
+			for _, t := range traders {
+				t.s.trade(t.p, stocks, prev, rng)
+			}
+			prev = stocks
+			turn := start.AddDate(0, s, d+1)
+			game.Advance(turn)
+			for _, v := range game.Prices(turn) {
+				st, ok := stats[v.Stock]
+				if !ok {
+					st = &stockStats{}
+					stats[v.Stock] = st
+				}
+				st.days++
+				st.value += v.Open
+				st.dividends += v.Dividend
+				st.splits += v.Split
+				if v.Bankrupt {
+					st.bankrupt++
+				}
+			}
+		}
+		for _, v := range game.Leaders() {
+			worth[strategyOf[v.Name]] = append(worth[strategyOf[v.Name]], v.Worth)
+		}
+		game.Advance(start.AddDate(0, s+1, 0))
+	}
+
+	fmt.Printf("Simulated %d seasons of %d turns with the %s market (seed %d)\n\n",
+		*seasons, *days, game.MarketModel(), *seed)
+	names := make([]string, 0, len(stats))
+	for k := range stats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Printf("%-12s %6s %12s %12s %15s\n", "Stock", "Turns", "Bankruptcy", "Split", "Dividend yield")
+	for _, k := range names {
+		st := stats[k]
+		fmt.Printf("%-12s %6d %11.1f%% %11.1f%% %14.2f%%\n", k, st.days,
+			float64(st.bankrupt)/float64(st.days)*100,
+			float64(st.splits)/float64(st.days)*100,
+			float64(st.dividends)/float64(st.value)*100)
+	}
+
+	fmt.Printf("\nEnd of season net worth\n")
+	fmt.Printf("%-14s %10s %10s %10s %10s %10s\n", "Strategy", "Minimum", "Quartile", "Median", "Quartile", "Maximum")
+	for _, v := range strategies {
+		w := worth[v.name]
+		if len(w) < 1 {
+			continue
+		}
+		sort.Slice(w, func(i, j int) bool { return w[i] < w[j] })
+		fmt.Printf("%-14s %10d %10d %10d %10d %10d\n", v.name,
+			w[0], w[len(w)/4], w[len(w)/2], w[len(w)*3/4], w[len(w)-1])
+	}
+}
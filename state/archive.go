@@ -0,0 +1,147 @@
+package state
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// SeasonSummary describes a season that has ended. Winner is the player who
+// finished first, with a net worth of Worth, out of Players players.
+type SeasonSummary struct {
+	Number  int
+	Name    string
+	Start   time.Time
+	End     time.Time
+	Winner  string
+	Worth   uint64
+	Players int
+}
+
+// SeasonHolding is a position a player held at the end of a season, valued
+// at the stock's final price.
+type SeasonHolding struct {
+	Stock  string
+	Shares uint64
+	Short  uint64
+	Price  uint64
+}
+
+// SeasonResult is where a player finished at the end of a season. Players
+// with the same net worth share a rank.
+type SeasonResult struct {
+	Rank     int
+	Name     string
+	Worth    uint64
+	Cash     uint64
+	Holdings []SeasonHolding
+}
+
+// SeasonArchive is the final standings of a season, with every player's
+// portfolio and the final stock prices.
+type SeasonArchive struct {
+	SeasonSummary
+	Results []SeasonResult
+	Stocks  []Stock
+}
+
+// archiveSeason records the final standings of the current season, which
+// ends at end, before the game is reset. leader must be sorted.
+func (g *Game) archiveSeason(tx *sql.Tx, end time.Time, leader []LeaderInfo) {
+	number := 1
+	tx.Stmt(g.getGame).QueryRow("Season").Scan(&number)
+	start := g.seasonStart(tx)
+	_, err := tx.Stmt(g.addSeason).Exec(number, g.seasonName(tx, end),
+		start.UTC().Format(sqliteDate), end.UTC().Format(sqliteDate))
+	if err != nil {
+		log.Println(err)
+	}
+	rank := 0
+	for k, v := range leader {
+		if k == 0 || v.Worth != leader[k-1].Worth {
+			rank = k + 1
+		}
+		tx.Stmt(g.addSeasonResult).Exec(number, v.Name, rank, v.Worth)
+	}
+}
+
+func (g *Game) scanSeason(sum *SeasonSummary, start, end string) {
+	loc := g.Location()
+	sum.Start, _ = time.Parse(sqliteDate, start)
+	sum.End, _ = time.Parse(sqliteDate, end)
+	sum.Start, sum.End = sum.Start.In(loc), sum.End.In(loc)
+}
+
+// Seasons returns every season that has ended, most recent first.
+func (g *Game) Seasons() []SeasonSummary {
+	rv := make([]SeasonSummary, 0)
+	r, err := g.getSeasons.Query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var s SeasonSummary
+		var start, end string
+		r.Scan(&s.Number, &s.Name, &start, &end, &s.Winner, &s.Worth, &s.Players)
+		g.scanSeason(&s, start, end)
+		rv = append(rv, s)
+	}
+	return rv
+}
+
+// SeasonArchive returns the final standings of season number, or nil if that
+// season hasn't ended.
+func (g *Game) SeasonArchive(number int) *SeasonArchive {
+	var rv SeasonArchive
+	var start, end string
+	err := g.getSeason.QueryRow(number).Scan(&rv.Number, &rv.Name, &start, &end)
+	if err != nil {
+		return nil
+	}
+	g.scanSeason(&rv.SeasonSummary, start, end)
+
+	r, err := g.getSeasonResults.Query(number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	byPlayer := make(map[int]int)
+	for r.Next() {
+		var id int
+		var sr SeasonResult
+		r.Scan(&id, &sr.Name, &sr.Rank, &sr.Worth, &sr.Cash)
+		byPlayer[id] = len(rv.Results)
+		rv.Results = append(rv.Results, sr)
+	}
+	r.Close()
+	if len(rv.Results) > 0 {
+		rv.Winner, rv.Worth = rv.Results[0].Name, rv.Results[0].Worth
+	}
+	rv.Players = len(rv.Results)
+
+	r, err = g.getSeasonHoldings.Query(number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for r.Next() {
+		var id int
+		var sh SeasonHolding
+		r.Scan(&id, &sh.Stock, &sh.Shares, &sh.Short, &sh.Price)
+		if k, ok := byPlayer[id]; ok {
+			rv.Results[k].Holdings = append(rv.Results[k].Holdings, sh)
+		}
+	}
+	r.Close()
+
+	r, err = g.getSeasonStocks.Query(number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var s Stock
+		r.Scan(&s.Name, &s.Value)
+		rv.Stocks = append(rv.Stocks, s)
+	}
+	return &rv
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/subtle"
+	"encoding/base64"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"sync"
+	"strings"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/sha3"
@@ -81,50 +83,158 @@ func GetSeed() (rv int64) {
 	return
 }
 
-var pwdMutex sync.Mutex
+// turnSeed returns the random number seed for the turn at date. It is
+// derived from the game's seed, so that any turn can be replayed.
+func (g *Game) turnSeed(date string) int64 {
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], uint64(g.seed()))
+	sum := KMAC128("turn", seed[:], []byte(date), 64)
+	return int64(binary.LittleEndian.Uint64(sum))
+}
+
+// argonParams are the parameters of argon2id. Memory is in KiB.
+type argonParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// legacyArgon are the parameters used before they were stored with each
+// hash, when PWHash was just "argon2".
+var legacyArgon = argonParams{time: 1, memory: 64 * 1024, threads: 4}
+
+// argonKeyLen is the length of an argon2id password hash, in bytes.
+const argonKeyLen = 32
 
-func pwdHash(salt []byte, password string) []byte {
-	// With this lock, it's easy to DoS the comprod instance.
-	// Without this lock, it's easy to DoS the entire machine.
-	pwdMutex.Lock()
-	defer pwdMutex.Unlock()
-	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+func (g *Game) argonParams() argonParams {
+	s := g.Settings()
+	return argonParams{time: uint32(s.ArgonTime), memory: uint32(s.ArgonMemory), threads: uint8(s.ArgonThreads)}
+}
+
+// pwdHash hashes password with argon2id. Only as many passwords are hashed
+// at once as fit in the hash memory (and the number of CPUs), so that
+// logins can't use up the machine. If too many are already waiting,
+// pwdHash returns ErrBusy.
+func (g *Game) pwdHash(params argonParams, salt []byte, password string) ([]byte, error) {
+	weight, err := hashing.acquire(int64(params.memory), g.hashMemory())
+	if err != nil {
+		return nil, err
+	}
+	defer hashing.release(weight)
+	return argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, argonKeyLen), nil
+}
+
+// phc formats an argon2id hash in the PHC string format, which records the
+// parameters along with the salt and the hash.
+func (a argonParams) phc(salt, hash []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.memory, a.time, a.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+// parsePHC parses an argon2id hash in the PHC string format.
+func parsePHC(s string) (params argonParams, salt, hash []byte, err error) {
+	f := strings.Split(s, "$")
+	if len(f) != 6 || f[0] != "" || f[1] != "argon2id" {
+		return params, nil, nil, errors.New("Not an argon2id hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(f[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("Unsupported argon2 version %q", f[2])
+	}
+	if _, err = fmt.Sscanf(f[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("Bad argon2 parameters %q", f[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(f[4]); err != nil {
+		return params, nil, nil, err
+	}
+	hash, err = base64.RawStdEncoding.DecodeString(f[5])
+	return params, salt, hash, err
 }
 
-func (p *PlayerInfo) SetPassword(pw string) {
+// SetPassword hashes pw with the game's current argon2id parameters.
+func (p *PlayerInfo) SetPassword(pw string) error {
+	params := p.g.argonParams()
 	salt := make([]byte, 256/8)
 	rand.Read(salt)
-	password := pwdHash(salt, pw)
-	p.g.setPassword.Exec(p.playerID, "argon2", salt, password)
+	hash, err := p.g.pwdHash(params, salt, pw)
+	if err != nil {
+		return err
+	}
+	_, err = p.g.setPassword.Exec(p.playerID, params.phc(salt, hash), nil, nil)
+	return err
+}
+
+// dummySalt is the salt of the hash computed for a login by an unknown player.
+var dummySalt = make([]byte, 256/8)
+
+// dummyHash hashes pw as if it were being checked against a password hashed
+// with the current parameters, so that failing to find a password takes as
+// long as checking one.
+func (g *Game) dummyHash(pw string) error {
+	_, err := g.pwdHash(g.argonParams(), dummySalt, pw)
+	return err
+}
+
+// CheckLogin returns the named player if pw is their password, or nil if it
+// isn't, or if there is no such player. Either way, pw is hashed, so that
+// the time CheckLogin takes doesn't reveal whether the player exists. The
+// error is ErrBusy if the password couldn't be checked yet.
+func (g *Game) CheckLogin(name, pw string) (*PlayerInfo, error) {
+	p := g.Player(name)
+	if p == nil {
+		return nil, g.dummyHash(pw)
+	}
+	ok, err := p.CheckPassword(pw)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func (p *PlayerInfo) CheckPassword(pw string) bool {
+// CheckPassword checks pw against the player's password. Passwords hashed
+// with older parameters, or an older algorithm, are rehashed with the
+// current parameters when they match. The error is ErrBusy if the password
+// couldn't be checked yet.
+func (p *PlayerInfo) CheckPassword(pw string) (bool, error) {
 	row := p.g.getPassword.QueryRow(p.playerID)
 	var hash string
 	var salt, password []byte
 	err := row.Scan(&hash, &salt, &password)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return false, p.g.dummyHash(pw)
 	}
-	switch hash {
-	case "argon2":
-		pwh := pwdHash(salt, pw)
-		return subtle.ConstantTimeCompare(password, pwh) == 1
+	var pwh []byte
+	var current bool
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		var params argonParams
+		params, salt, password, err = parsePHC(hash)
+		if err != nil {
+			fmt.Println(err)
+			return false, p.g.dummyHash(pw)
+		}
+		pwh, err = p.g.pwdHash(params, salt, pw)
+		current = params == p.g.argonParams()
+	case hash == "argon2":
+		pwh, err = p.g.pwdHash(legacyArgon, salt, pw)
+	case hash == "sha1":
+		// Imported from the original comprod. SHA-1 is so much faster
+		// than argon2 that it would tell these players from unknown ones.
+		pwh = legacyHash(salt, pw)
+		err = p.g.dummyHash(pw)
 	default:
 		fmt.Println("No hash ", hash)
 		// Unrecognized password hash
-		return false
+		return false, p.g.dummyHash(pw)
 	}
-}
-
-func (p *PlayerInfo) NewCookie() []byte {
-	cookie := make([]byte, 256/8)
-	rand.Read(cookie)
-	p.g.setCookie.Exec(p.playerID, cookie)
-	return cookie
-}
-
-func (p *PlayerInfo) ClearCookie() {
-	p.g.setCookie.Exec(p.playerID, nil)
+	if err != nil {
+		return false, err
+	}
+	ok := subtle.ConstantTimeCompare(password, pwh) == 1
+	if ok && !current {
+		// Upgrading can wait until next time, if the server is busy
+		p.SetPassword(pw)
+	}
+	return ok, nil
 }
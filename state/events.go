@@ -0,0 +1,38 @@
+package state
+
+// Event tells listeners that the game has changed. Kind is "turn" after
+// the daily market adjustments, or "trade" after a player trades.
+type Event struct {
+	Kind string
+}
+
+// Listen returns a channel which receives an Event whenever the game
+// changes, and a function to call to stop listening. Events are dropped
+// if the listener falls behind, so a listener should fetch the latest
+// state of the game after each one.
+func (g *Game) Listen() (<-chan Event, func()) {
+	ch := make(chan Event, 1)
+	g.listenMu.Lock()
+	if g.listeners == nil {
+		g.listeners = make(map[chan Event]bool)
+	}
+	g.listeners[ch] = true
+	g.listenMu.Unlock()
+	return ch, func() {
+		g.listenMu.Lock()
+		delete(g.listeners, ch)
+		g.listenMu.Unlock()
+	}
+}
+
+// Notify sends an Event of the given kind to every listener.
+func (g *Game) Notify(kind string) {
+	g.listenMu.Lock()
+	defer g.listenMu.Unlock()
+	for ch := range g.listeners {
+		select {
+		case ch <- Event{Kind: kind}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Offer is a bid to buy (Side "bid") or an ask to sell (Side "ask") shares
+// on the exchange, where players trade with each other rather than with
+// the house. Offers stay on the book until they are filled or withdrawn,
+// or until the end of the season.
+type Offer struct {
+	ID      int64
+	Stock   string
+	Side    string
+	Shares  uint64
+	Price   uint64
+	Created time.Time
+}
+
+// DepthLevel is the total of the offers on one side of the book at a single
+// price.
+type DepthLevel struct {
+	Price  uint64
+	Shares uint64
+	Offers int
+}
+
+// PostOffer posts a bid or ask for lots of stock at price. The offer is
+// matched against the opposite side of the book first, best price first and
+// then oldest first, and each match trades at the price of the offer that
+// was already on the book. Whatever isn't filled stays on the book. An
+// offer on the book whose owner can no longer pay for it (or deliver the
+// shares, or keep their short margin) is withdrawn when it is matched. PostOffer returns the number of
+// shares traded.
+func (p *PlayerInfo) PostOffer(stock, side string, lots, price uint64) (uint64, error) {
+	if lots < 1 {
+		return 0, errors.New("An offer must be for at least one board lot")
+	}
+	if price < 1 {
+		return 0, errors.New("The price must be at least $1")
+	}
+	// No stock is worth more than the largest split value
+	if maxPrice := ruleMax("SplitValue"); price > maxPrice {
+		return 0, fmt.Errorf("The price must be at most $%d", maxPrice)
+	}
+	var other string
+	switch side {
+	case "bid":
+		other = "ask"
+	case "ask":
+		other = "bid"
+	default:
+		return 0, errors.New("Unrecognized offer: " + side)
+	}
+	lotSize := p.g.Rules().LotSize
+	if lots > math.MaxUint64/lotSize {
+		return 0, errors.New("That is too many board lots")
+	}
+	shares := lots * lotSize
+
+	type match struct {
+		id     int64
+		player int
+		shares uint64
+		price  uint64
+	}
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	for {
+		tx, _ = p.g.db.Begin()
+		idx := p.g.findStock(tx, stock)
+		if idx < 0 {
+			return 0, fmt.Errorf("%s is not on the market", stock)
+		}
+		var have uint64
+		if side == "bid" {
+			tx.Stmt(p.g.getHolding).QueryRow(p.playerID, "Cash").Scan(&have)
+			if price > have/shares {
+				return 0, fmt.Errorf("You don't have enough cash to bid for %d shares of %s", shares, stock)
+			}
+		} else {
+			tx.Stmt(p.g.getHolding).QueryRow(p.playerID, idx).Scan(&have)
+			if have < shares {
+				return 0, fmt.Errorf("You don't have %d shares of %s to offer", shares, stock)
+			}
+		}
+
+		r, err := tx.Stmt(p.g.matchOffers).Query(idx, other, p.playerID, price)
+		if err != nil {
+			if isBusy(err) {
+				tx.Rollback()
+				continue
+			}
+			return 0, err
+		}
+		var matches []match
+		for r.Next() {
+			var m match
+			r.Scan(&m.id, &m.player, &m.shares, &m.price)
+			matches = append(matches, m)
+		}
+		r.Close()
+
+		remain := shares
+		for _, m := range matches {
+			if remain == 0 {
+				break
+			}
+			n := m.shares
+			if n > remain {
+				n = remain
+			}
+			buyer, seller := p.playerID, m.player
+			if side == "ask" {
+				buyer, seller = m.player, p.playerID
+			}
+			var cash, held, short uint64
+			tx.Stmt(p.g.getHolding).QueryRow(buyer, "Cash").Scan(&cash)
+			tx.Stmt(p.g.getHolding).QueryRow(seller, idx).Scan(&held)
+			if side == "ask" {
+				// A bid on the book mustn't spend the cash that covers its
+				// owner's short positions. The new bid's margin is checked
+				// below, once all of its trades are made.
+				tx.Stmt(p.g.shortValue).QueryRow(buyer).Scan(&short)
+			}
+			if m.price > cash/n || held < n || (cash-n*m.price)*100 < short*shortMargin {
+				// Only the offer on the book can be short of funds, since
+				// the new offer was checked above
+				tx.Stmt(p.g.takeOffer).Exec(m.id, m.shares)
+				continue
+			}
+			tx.Stmt(p.g.fillBuy).Exec(buyer, idx, n, m.price)
+			tx.Stmt(p.g.fillSell).Exec(seller, idx, n, m.price)
+			tx.Stmt(p.g.addLedger).Exec(buyer, idx, int64(n), m.price, "exchange buy")
+			tx.Stmt(p.g.addLedger).Exec(seller, idx, -int64(n), m.price, "exchange sell")
+			tx.Stmt(p.g.takeOffer).Exec(m.id, n)
+			remain -= n
+		}
+		if side == "bid" && remain < shares {
+			if err = p.g.checkMargin(tx, p.playerID, shortMargin); err != nil {
+				return 0, err
+			}
+		}
+		if remain > 0 {
+			tx.Stmt(p.g.addOffer).Exec(p.playerID, idx, side, remain, price)
+		}
+		err = tx.Commit()
+		if isBusy(err) {
+			tx.Rollback()
+			continue
+		}
+		if err == nil {
+			tx = nil
+		}
+		return shares - remain, err
+	}
+}
+
+func (p *PlayerInfo) Offers() []Offer {
+	rv := make([]Offer, 0)
+	r, err := p.g.getOffers.Query(p.playerID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var o Offer
+		var created string
+		r.Scan(&o.ID, &o.Stock, &o.Side, &o.Shares, &o.Price, &created)
+		o.Created, _ = time.Parse(sqliteDate, created)
+		rv = append(rv, o)
+	}
+	return rv
+}
+
+func (p *PlayerInfo) WithdrawOffer(id int64) error {
+	r := p.g.withdrawOffer.QueryRow(id, p.playerID)
+	err := r.Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("No such offer")
+	}
+	return err
+}
+
+// Depth returns the book for stock: the bids, best (highest) first, and the
+// asks, best (lowest) first.
+func (g *Game) Depth(stock string) (bids, asks []DepthLevel) {
+	r, err := g.getDepth.Query(stock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var side string
+		var l DepthLevel
+		r.Scan(&side, &l.Price, &l.Shares, &l.Offers)
+		if side == "bid" {
+			bids = append(bids, l)
+		} else {
+			asks = append([]DepthLevel{l}, asks...)
+		}
+	}
+	return bids, asks
+}
@@ -0,0 +1,160 @@
+package state
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPostOffer(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0].Name
+	value := g.ListStocks()[0].Value
+	lot := g.Rules().LotSize
+	cash := g.Rules().StartingCash
+
+	alice := testPlayer(t, g, "alice")
+	sellers := make(map[string]*PlayerInfo)
+	for _, name := range []string{"bob", "carol", "dave"} {
+		sellers[name] = testPlayer(t, g, name)
+		if err := sellers[name].Buy(stock, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range []struct {
+		name  string
+		price uint64
+	}{{"bob", 12}, {"carol", 11}, {"dave", 11}} {
+		n, err := sellers[v.name].PostOffer(stock, "ask", 1, v.price)
+		if err != nil || n != 0 {
+			t.Fatalf("%s asks: %d, %v", v.name, n, err)
+		}
+	}
+
+	// Best price first, then oldest first, each at the price on the book
+	n, err := alice.PostOffer(stock, "bid", 2, 12)
+	if err != nil || n != 2*lot {
+		t.Fatalf("Bid filled %d, %v; want %d", n, err, 2*lot)
+	}
+	h := alice.Holdings()
+	if h.Shares[stock] != 2*lot || h.Cash != cash-2*lot*11 {
+		t.Errorf("alice has %d shares and $%d", h.Shares[stock], h.Cash)
+	}
+	for name, want := range map[string]uint64{"bob": lot, "carol": 0, "dave": 0} {
+		if got := sellers[name].Holdings().Shares[stock]; got != want {
+			t.Errorf("%s has %d shares, want %d", name, got, want)
+		}
+	}
+	if got := sellers["carol"].Holdings().Cash; got != cash-lot*value+lot*11 {
+		t.Errorf("carol has $%d", got)
+	}
+
+	// A partial fill leaves the rest on the book
+	n, err = alice.PostOffer(stock, "bid", 3, 12)
+	if err != nil || n != lot {
+		t.Fatalf("Bid filled %d, %v; want %d", n, err, lot)
+	}
+	offers := alice.Offers()
+	if len(offers) != 1 || offers[0].Shares != 2*lot || offers[0].Side != "bid" || offers[0].Price != 12 {
+		t.Errorf("alice's offers are %+v", offers)
+	}
+	if len(sellers["bob"].Offers()) != 0 {
+		t.Errorf("bob's ask is still on the book")
+	}
+	alice.WithdrawOffer(offers[0].ID)
+
+	// A bid whose owner spent the cash is withdrawn when matched
+	erin := testPlayer(t, g, "erin")
+	if _, err = erin.PostOffer(stock, "bid", 1, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err = erin.Buy(stock, cash/(lot*value)); err != nil {
+		t.Fatal(err)
+	}
+	n, err = alice.PostOffer(stock, "ask", 1, 50)
+	if err != nil || n != 0 {
+		t.Fatalf("Ask filled %d, %v; want 0", n, err)
+	}
+	if len(erin.Offers()) != 0 {
+		t.Errorf("erin's bid is still on the book")
+	}
+	if len(alice.Offers()) != 1 {
+		t.Errorf("alice's ask isn't on the book")
+	}
+}
+
+func TestPostOfferOverflow(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0].Name
+	lot := g.Rules().LotSize
+	alice := testPlayer(t, g, "alice")
+	bob := testPlayer(t, g, "bob")
+	if err := bob.Buy(stock, 1); err != nil {
+		t.Fatal(err)
+	}
+	before := alice.Holdings().Cash
+
+	for _, v := range []struct {
+		lots, price uint64
+	}{
+		{1, 1 << 62},
+		{1, ruleMax("SplitValue") + 1},
+		{math.MaxUint64/lot + 1, 1},
+		{math.MaxUint64 / lot, 2},
+		{1 << 56, 1 << 8},
+	} {
+		if n, err := alice.PostOffer(stock, "bid", v.lots, v.price); err == nil || n != 0 {
+			t.Errorf("Bid for %d lots at $%d filled %d, %v", v.lots, v.price, n, err)
+		}
+	}
+	if _, err := bob.PostOffer(stock, "ask", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := alice.Holdings().Cash; got != before {
+		t.Errorf("alice has $%d, want $%d", got, before)
+	}
+	if got := bob.Holdings().Shares[stock]; got != lot {
+		t.Errorf("bob has %d shares, want %d", got, lot)
+	}
+}
+
+func TestPostOfferRestingMargin(t *testing.T) {
+	g := testGame(t)
+	stocks := g.ListStocks()
+	short, stock := stocks[0], stocks[1]
+	lot := g.Rules().LotSize
+	cash := g.Rules().StartingCash
+
+	// carol's bid would spend some of the cash that covers their short sale
+	const shortLots = 4
+	value := shortLots * lot * short.Value
+	spare := cash + value - value*shortMargin/100
+	lots := spare/(lot*stock.Value) + 1
+	if lots*lot*stock.Value > cash {
+		t.Fatal("The test needs more starting cash")
+	}
+	carol := testPlayer(t, g, "carol")
+	if err := carol.Short(short.Name, shortLots); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := carol.PostOffer(stock.Name, "bid", lots, stock.Value); n != 0 || err != nil {
+		t.Fatalf("carol bids: %d, %v", n, err)
+	}
+
+	dave := testPlayer(t, g, "dave")
+	if err := dave.Buy(stock.Name, lots); err != nil {
+		t.Fatal(err)
+	}
+	n, err := dave.PostOffer(stock.Name, "ask", lots, stock.Value)
+	if n != 0 || err != nil {
+		t.Fatalf("dave asks: %d, %v", n, err)
+	}
+	if h := carol.Holdings(); h.Shares[stock.Name] != 0 || h.Cash != cash+value {
+		t.Errorf("carol bought below their margin: %+v", h)
+	}
+	if len(carol.Offers()) != 0 {
+		t.Error("carol's bid is still on the book")
+	}
+	if len(dave.Offers()) != 1 {
+		t.Error("dave's ask isn't on the book")
+	}
+}
@@ -3,19 +3,18 @@ package state
 import (
 	"database/sql"
 	_ "embed"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"sync"
+	"time"
 
 	"modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
 )
 
-const stockTypes = 6
-const startingValue = 100
-const splitValue = startingValue * 2
-const startingCash = 100000
-
 //go:embed sql/create
 var sqlCreate string
 
@@ -37,11 +36,17 @@ var findStock string
 //go:embed sql/findplayer
 var findPlayer string
 
-//go:embed sql/findbycookie
-var findPlayerByCookie string
+//go:embed sql/findbysession
+var findPlayerBySession string
+
+//go:embed sql/addsession
+var addSession string
+
+//go:embed sql/getsessions
+var getSessions string
 
-//go:embed sql/setcookie
-var setCookie string
+//go:embed sql/endsession
+var endSession string
 
 //go:embed sql/addplayer
 var addPlayer string
@@ -85,12 +90,21 @@ var getNews string
 //go:embed sql/addhistory
 var addHistory string
 
+//go:embed sql/addhistorydated
+var addHistoryDated string
+
 //go:embed sql/gethistory
 var getHistory string
 
 //go:embed sql/getholding
 var getHolding string
 
+//go:embed sql/getholdings
+var getHoldings string
+
+//go:embed sql/getshorts
+var getShorts string
+
 //go:embed sql/setholding
 var setHolding string
 
@@ -106,9 +120,168 @@ var getLeaders string
 //go:embed sql/reset
 var resetGame string
 
+//go:embed sql/addprice
+var addPrice string
+
+//go:embed sql/getprices
+var getPrices string
+
+//go:embed sql/addledger
+var addLedger string
+
+//go:embed sql/addledgercash
+var addLedgerCash string
+
+//go:embed sql/addturn
+var addTurn string
+
+//go:embed sql/getturns
+var getTurns string
+
+//go:embed sql/getturnprices
+var getTurnPrices string
+
+//go:embed sql/getledger
+var getLedger string
+
+//go:embed sql/getdeletedledger
+var getDeletedLedger string
+
+//go:embed sql/addorder
+var addOrder string
+
+//go:embed sql/getorders
+var getOrders string
+
+//go:embed sql/cancelorder
+var cancelOrder string
+
+//go:embed sql/expireorders
+var expireOrders string
+
+//go:embed sql/findorders
+var findOrders string
+
+//go:embed sql/findtriggers
+var findTriggers string
+
+//go:embed sql/short
+var shortStock string
+
+//go:embed sql/cover
+var coverStock string
+
+//go:embed sql/getshort
+var getShort string
+
+//go:embed sql/listshorts
+var listShorts string
+
+//go:embed sql/shortvalue
+var shortValue string
+
+//go:embed sql/getstockvalue
+var getStockValue string
+
+//go:embed sql/getplayername
+var getPlayerName string
+
+//go:embed sql/fillbuy
+var fillBuy string
+
+//go:embed sql/fillsell
+var fillSell string
+
+//go:embed sql/addtoken
+var addToken string
+
+//go:embed sql/gettokens
+var getTokens string
+
+//go:embed sql/deletetoken
+var deleteToken string
+
+//go:embed sql/findbytoken
+var findPlayerByToken string
+
+//go:embed sql/addoffer
+var addOffer string
+
+//go:embed sql/matchoffers
+var matchOffers string
+
+//go:embed sql/takeoffer
+var takeOffer string
+
+//go:embed sql/withdrawoffer
+var withdrawOffer string
+
+//go:embed sql/getoffers
+var getOffers string
+
+//go:embed sql/getdepth
+var getDepth string
+
+//go:embed sql/addseasondate
+var addSeasonDate string
+
+//go:embed sql/getseasondates
+var getSeasonDates string
+
+//go:embed sql/deleteseasondate
+var deleteSeasonDate string
+
+//go:embed sql/addseason
+var addSeason string
+
+//go:embed sql/addseasonresult
+var addSeasonResult string
+
+//go:embed sql/getseasons
+var getSeasons string
+
+//go:embed sql/getseason
+var getSeason string
+
+//go:embed sql/getseasonresults
+var getSeasonResults string
+
+//go:embed sql/getseasonholdings
+var getSeasonHoldings string
+
+//go:embed sql/getseasonstocks
+var getSeasonStocks string
+
+//go:embed sql/getallresults
+var getAllResults string
+
+//go:embed sql/getloginfailure
+var getLoginFailure string
+
+//go:embed sql/addloginfailure
+var addLoginFailure string
+
+//go:embed sql/setlockeduntil
+var setLockedUntil string
+
+//go:embed sql/clearloginfailure
+var clearLoginFailure string
+
+//go:embed sql/getlockouts
+var getLockouts string
+
+//go:embed sql/addaudit
+var addAudit string
+
+//go:embed sql/getaudit
+var getAudit string
+
+// PlayerHoldings are a player's cash and the shares they own (and have
+// sold short), indexed by stock name.
 type PlayerHoldings struct {
 	Cash   uint64
-	Shares [stockTypes]uint64
+	Shares map[string]uint64
+	Short  map[string]uint64
 }
 
 type Stock struct {
@@ -116,16 +289,46 @@ type Stock struct {
 	Value uint64
 }
 
+// PricePoint is one day of trading for a single stock. Open is the price
+// before the day's adjustments, and Close is the price afterward (0 if the
+// stock went bankrupt). Split counts the 2 for 1 splits during the day.
+type PricePoint struct {
+	Stock    string
+	Season   int
+	Date     time.Time
+	Open     uint64
+	Close    uint64
+	Dividend uint64
+	Split    int
+	Bankrupt bool
+}
+
+// LedgerEntry records a single change to a player's holdings. Shares and
+// Cash are the change in the player's shares of Stock and cash on hand,
+// and Price is the per share price (or dividend) that applied.
+type LedgerEntry struct {
+	Date   time.Time
+	Stock  string
+	Shares int64
+	Cash   int64
+	Price  uint64
+	Reason string
+}
+
 type Game struct {
 	db                          *sql.DB
+	listenMu                    sync.Mutex
+	listeners                   map[chan Event]bool
 	getGame, setGame            *sql.Stmt
 	getPassword, setPassword    *sql.Stmt
 	findStockIndex              *sql.Stmt
 	addPlayer                   *sql.Stmt
 	findPlayer, deletePlayer    *sql.Stmt
-	findPlayerByCookie          *sql.Stmt
-	setCookie                   *sql.Stmt
+	findPlayerBySession         *sql.Stmt
+	addSession, getSessions     *sql.Stmt
+	endSession                  *sql.Stmt
 	getHolding, getLeaders      *sql.Stmt
+	getHoldings, getShorts      *sql.Stmt
 	setHolding                  *sql.Stmt
 	addStock                    *sql.Stmt
 	setStockName, setStockValue *sql.Stmt
@@ -137,11 +340,50 @@ type Game struct {
 	getNews, addNews            *sql.Stmt
 	getHistory, addHistory      *sql.Stmt
 	resetGame                   *sql.Stmt
+	addPrice, getPrices         *sql.Stmt
+	addLedger, getLedger        *sql.Stmt
+	addLedgerCash               *sql.Stmt
+	getDeletedLedger            *sql.Stmt
+	addTurn, getTurns           *sql.Stmt
+	getTurnPrices               *sql.Stmt
+	addOrder, getOrders         *sql.Stmt
+	cancelOrder, expireOrders   *sql.Stmt
+	findOrders, findTriggers    *sql.Stmt
+	fillBuy, fillSell           *sql.Stmt
+	short, cover                *sql.Stmt
+	getShort, listShorts        *sql.Stmt
+	shortValue, getStockValue   *sql.Stmt
+	getPlayerName               *sql.Stmt
+	addToken, getTokens         *sql.Stmt
+	deleteToken                 *sql.Stmt
+	findPlayerByToken           *sql.Stmt
+	addOffer, matchOffers       *sql.Stmt
+	takeOffer, withdrawOffer    *sql.Stmt
+	getOffers, getDepth         *sql.Stmt
+	addSeasonDate               *sql.Stmt
+	getSeasonDates              *sql.Stmt
+	deleteSeasonDate            *sql.Stmt
+	addSeason, addSeasonResult  *sql.Stmt
+	getSeasons, getSeason       *sql.Stmt
+	getSeasonResults            *sql.Stmt
+	getSeasonHoldings           *sql.Stmt
+	getSeasonStocks             *sql.Stmt
+	getAllResults               *sql.Stmt
+	addHistoryDated             *sql.Stmt
+	getLoginFailure             *sql.Stmt
+	addLoginFailure             *sql.Stmt
+	setLockedUntil              *sql.Stmt
+	clearLoginFailure           *sql.Stmt
+	getLockouts                 *sql.Stmt
+	addAudit                    *sql.Stmt
+	getAudit                    *sql.Stmt
 }
 
 type PlayerInfo struct {
 	playerID int
 	g        *Game
+	scope    string // Scope of the API token used, if any
+	session  int64  // ID of the session used, if any
 }
 
 type LeaderInfo struct {
@@ -164,14 +406,36 @@ func isBusy(err error) bool {
 	return serr.Code() == sqlite3.SQLITE_BUSY
 }
 
+// ErrNoLots and ErrTooManyLots are returned when a trade is for no board
+// lots, or for more shares than can be counted.
+var (
+	ErrNoLots      = errors.New("A trade must be for at least one board lot")
+	ErrTooManyLots = errors.New("That is too many board lots")
+)
+
+// lotShares returns the number of shares in lots board lots.
+func (g *Game) lotShares(lots uint64) (uint64, error) {
+	if lots < 1 {
+		return 0, ErrNoLots
+	}
+	lotSize := g.Rules().LotSize
+	if lots > math.MaxUint64/lotSize {
+		return 0, ErrTooManyLots
+	}
+	return lots * lotSize, nil
+}
+
 func (p *PlayerInfo) Buy(stock string, lots uint64) error {
+	shares, err := p.g.lotShares(lots)
+	if err != nil {
+		return err
+	}
 	var tx *sql.Tx
 	defer func() {
 		if tx != nil {
 			tx.Rollback()
 		}
 	}()
-	shares := lots * 100
 	for {
 		tx, _ = p.g.db.Begin()
 		idx := p.g.findStock(tx, stock)
@@ -190,8 +454,12 @@ func (p *PlayerInfo) Buy(stock string, lots uint64) error {
 			return err
 		}
 		if cash < 0 {
-			return fmt.Errorf("You don't have enough cash to buy %d shares of %s", lots*100, stock)
+			return fmt.Errorf("You don't have enough cash to buy %d shares of %s", shares, stock)
 		}
+		if err = p.g.checkMargin(tx, p.playerID, shortMargin); err != nil {
+			return err
+		}
+		tx.Stmt(p.g.addLedger).Exec(p.playerID, idx, shares, nil, "buy")
 		err = tx.Commit()
 		if isBusy(err) {
 			tx.Rollback()
@@ -205,13 +473,16 @@ func (p *PlayerInfo) Buy(stock string, lots uint64) error {
 }
 
 func (p *PlayerInfo) Sell(stock string, lots uint64) error {
+	shares, err := p.g.lotShares(lots)
+	if err != nil {
+		return err
+	}
 	var tx *sql.Tx
 	defer func() {
 		if tx != nil {
 			tx.Rollback()
 		}
 	}()
-	shares := lots * 100
 	for {
 		tx, _ = p.g.db.Begin()
 		idx := p.g.findStock(tx, stock)
@@ -232,6 +503,7 @@ func (p *PlayerInfo) Sell(stock string, lots uint64) error {
 		if sharesRemain < 0 {
 			return fmt.Errorf("You don't have %d shares of %s to sell", shares, stock)
 		}
+		tx.Stmt(p.g.addLedger).Exec(p.playerID, idx, -int64(shares), nil, "sell")
 		err = tx.Commit()
 		if isBusy(err) {
 			tx.Rollback()
@@ -245,12 +517,50 @@ func (p *PlayerInfo) Sell(stock string, lots uint64) error {
 }
 
 func (p *PlayerInfo) Holdings() PlayerHoldings {
-	var rv PlayerHoldings
+	rv := PlayerHoldings{Shares: make(map[string]uint64), Short: make(map[string]uint64)}
 	r := p.g.getHolding.QueryRow(p.playerID, "Cash")
 	r.Scan(&rv.Cash)
-	for i := 1; i <= stockTypes; i++ {
-		r = p.g.getHolding.QueryRow(p.playerID, i)
-		r.Scan(&rv.Shares[i-1])
+	for _, v := range []struct {
+		s *sql.Stmt
+		m map[string]uint64
+	}{{p.g.getHoldings, rv.Shares}, {p.g.getShorts, rv.Short}} {
+		rows, err := v.s.Query(p.playerID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for rows.Next() {
+			var name string
+			var shares uint64
+			rows.Scan(&name, &shares)
+			v.m[name] = shares
+		}
+		rows.Close()
+	}
+	return rv
+}
+
+func (p *PlayerInfo) Ledger() []LedgerEntry {
+	return scanLedger(p.g.getLedger.Query(p.playerID))
+}
+
+// DeletedLedger returns the ledger of the deleted player called name, so
+// that disputes can be investigated after a player is gone.
+func (g *Game) DeletedLedger(name string) []LedgerEntry {
+	return scanLedger(g.getDeletedLedger.Query(name))
+}
+
+func scanLedger(r *sql.Rows, err error) []LedgerEntry {
+	rv := make([]LedgerEntry, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var le LedgerEntry
+		var date string
+		r.Scan(&date, &le.Stock, &le.Shares, &le.Cash, &le.Price, &le.Reason)
+		le.Date, _ = time.Parse(sqliteDate, date)
+		rv = append(rv, le)
 	}
 	return rv
 }
@@ -270,8 +580,16 @@ func (p *PlayerInfo) SetAdmin(is bool) {
 }
 
 func (g *Game) ListStocks() []Stock {
+	return g.stocks(nil)
+}
+
+func (g *Game) stocks(t *sql.Tx) []Stock {
 	rv := make([]Stock, 0)
-	r, err := g.listStocks.Query()
+	s := g.listStocks
+	if t != nil {
+		s = t.Stmt(s)
+	}
+	r, err := s.Query()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -299,8 +617,96 @@ func getStrings(s *sql.Stmt) []string {
 	return rv
 }
 
-func (g *Game) History() []string {
-	return getStrings(g.getHistory)
+func (g *Game) PriceHistory(stock string) []PricePoint {
+	rv := make([]PricePoint, 0)
+	r, err := g.getPrices.Query(stock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		pp := PricePoint{Stock: stock}
+		var date string
+		r.Scan(&pp.Season, &date, &pp.Open, &pp.Close, &pp.Dividend, &pp.Split, &pp.Bankrupt)
+		pp.Date, _ = time.Parse(sqliteDate, date)
+		rv = append(rv, pp)
+	}
+	return rv
+}
+
+// Prices returns the price of every stock traded during the turn at time
+// turn, in the order the stocks are listed. A stock which went bankrupt
+// during the turn is followed by the stock which replaced it.
+func (g *Game) Prices(turn time.Time) []PricePoint {
+	rv := make([]PricePoint, 0)
+	r, err := g.getTurnPrices.Query(turn.UTC().Format(sqliteDate))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var pp PricePoint
+		var id int
+		var date string
+		r.Scan(&id, &pp.Stock, &pp.Season, &date, &pp.Open, &pp.Close, &pp.Dividend, &pp.Split, &pp.Bankrupt)
+		pp.Date, _ = time.Parse(sqliteDate, date)
+		rv = append(rv, pp)
+	}
+	return rv
+}
+
+// SetSeed sets the seed from which the random numbers for every future
+// turn are derived.
+func (g *Game) SetSeed(seed int64) {
+	g.setGame.Exec("Seed", seed)
+}
+
+// gameString returns the value of key in the Game table, or def if it has
+// none.
+func (g *Game) gameString(t *sql.Tx, key, def string) string {
+	s := g.getGame
+	if t != nil {
+		s = t.Stmt(s)
+	}
+	var rv string
+	if err := s.QueryRow(key).Scan(&rv); err != nil || len(rv) < 1 {
+		return def
+	}
+	return rv
+}
+
+// Season returns the number of the current season. The first season is 1.
+func (g *Game) Season() int {
+	r := g.getGame.QueryRow("Season")
+	rv := 1
+	r.Scan(&rv)
+	return rv
+}
+
+// HistoryItem is an entry in the game's history. Date is in the game's
+// time zone.
+type HistoryItem struct {
+	Date time.Time
+	Text string
+}
+
+func (g *Game) History() []HistoryItem {
+	rv := make([]HistoryItem, 0)
+	r, err := g.getHistory.Query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	loc := g.Location()
+	for r.Next() {
+		var h HistoryItem
+		var date string
+		r.Scan(&date, &h.Text)
+		h.Date, _ = time.Parse(sqliteDate, date)
+		h.Date = h.Date.In(loc)
+		rv = append(rv, h)
+	}
+	return rv
 }
 
 func (g *Game) HasPlayer(name string) bool {
@@ -337,22 +743,9 @@ func (g *Game) Player(name string) *PlayerInfo {
 	return &rv
 }
 
-func (g *Game) PlayerByCookie(cookie []byte) (string, *PlayerInfo) {
-	if cookie == nil || len(cookie) < 10 {
-		return "", nil
-	}
-	rv := PlayerInfo{g: g}
-	var name string
-	r := g.findPlayerByCookie.QueryRow(cookie)
-	err := r.Scan(&name, &rv.playerID)
-	if err != nil {
-		return "", nil
-	}
-	return name, &rv
-}
-
 func (g *Game) NewPlayer(name string) *PlayerInfo {
 	rv := PlayerInfo{g: g, playerID: -1}
+	rules := g.Rules()
 	for {
 		tx, _ := g.db.Begin()
 		r := tx.Stmt(g.addPlayer).QueryRow(name)
@@ -365,7 +758,7 @@ func (g *Game) NewPlayer(name string) *PlayerInfo {
 			tx.Rollback()
 			return nil
 		}
-		tx.Stmt(g.setHolding).Exec(rv.playerID, "Cash", startingCash)
+		tx.Stmt(g.setHolding).Exec(rv.playerID, "Cash", rules.StartingCash)
 		if tx.Commit() == nil {
 			return &rv
 		}
@@ -398,43 +791,39 @@ func (g *Game) News() []string {
 	return getStrings(g.getNews)
 }
 
-func (g *Game) pickName(t *sql.Tx) string {
-	names := [...]string{"Coffee", "Soybeans", "Corn", "Wheat", "Cocoa", "Gold", "Silver", "Platinum", "Oil", "Natural Gas", "Cotton", "Sugar", "Lithium", "Cobalt"}
+var stockNames = [...]string{"Coffee", "Soybeans", "Corn", "Wheat", "Cocoa", "Gold", "Silver", "Platinum", "Oil", "Natural Gas", "Cotton", "Sugar", "Lithium", "Cobalt"}
+
+// pickName picks the name of a new stock, which isn't already used by any
+// of stocks.
+func pickName(rng *rand.Rand, stocks []Stock) string {
+	names := stockNames
 	used := make(map[string]bool)
-	s := g.listStocks
-	if t != nil {
-		s = t.Stmt(s)
-	}
-	r, err := s.Query()
-	if err == nil {
-		defer r.Close()
-		for r.Next() {
-			var name string
-			var value int
-			r.Scan(&name, &value)
-			used[name] = true
-		}
+	for _, v := range stocks {
+		used[v.Name] = true
 	}
 	for {
-		i := rand.Intn(len(names))
+		i := rng.Intn(len(names))
 		if !used[names[i]] {
 			return names[i]
 		}
 	}
 }
 
-func (g *Game) reset(t *sql.Tx) {
+func (g *Game) reset(t *sql.Tx, rules Rules, rng *rand.Rand) {
 	s := g.resetGame
 	if t != nil {
 		s = t.Stmt(s)
 	}
-	s.Exec(startingCash)
+	s.Exec(rules.StartingCash)
 	s = g.addStock
 	if t != nil {
 		s = t.Stmt(s)
 	}
-	for i := 1; i <= stockTypes; i++ {
-		s.Exec(i, g.pickName(t), startingValue)
+	var listed []Stock
+	for i := uint64(1); i <= rules.Stocks; i++ {
+		name := pickName(rng, listed)
+		s.Exec(i, name, rules.StartingValue)
+		listed = append(listed, Stock{Name: name, Value: rules.StartingValue})
 	}
 }
 
@@ -446,6 +835,18 @@ func mustPrepare(db *sql.DB, stmt string) *sql.Stmt {
 	return s
 }
 
+// seed returns the game's random number seed, choosing one if the game
+// doesn't have one yet.
+func (g *Game) seed() int64 {
+	var rv int64
+	err := g.getGame.QueryRow("Seed").Scan(&rv)
+	if err != nil {
+		rv = GetSeed()
+		g.setGame.Exec("Seed", rv)
+	}
+	return rv
+}
+
 func (g *Game) getKey() []byte {
 	r := g.getGame.QueryRow("Key")
 	var rv []byte
@@ -465,8 +866,10 @@ func (g *Game) prepareAll() {
 	g.findStockIndex = mustPrepare(db, findStock)
 	g.addPlayer = mustPrepare(db, addPlayer)
 	g.findPlayer = mustPrepare(db, findPlayer)
-	g.findPlayerByCookie = mustPrepare(db, findPlayerByCookie)
-	g.setCookie = mustPrepare(db, setCookie)
+	g.findPlayerBySession = mustPrepare(db, findPlayerBySession)
+	g.addSession = mustPrepare(db, addSession)
+	g.getSessions = mustPrepare(db, getSessions)
+	g.endSession = mustPrepare(db, endSession)
 	g.deletePlayer = mustPrepare(db, deletePlayer)
 	g.addStock = mustPrepare(db, addStock)
 	g.buy = mustPrepare(db, buyStock)
@@ -482,40 +885,92 @@ func (g *Game) prepareAll() {
 	g.addHistory = mustPrepare(db, addHistory)
 	g.getHistory = mustPrepare(db, getHistory)
 	g.getHolding = mustPrepare(db, getHolding)
+	g.getHoldings = mustPrepare(db, getHoldings)
+	g.getShorts = mustPrepare(db, getShorts)
 	g.setHolding = mustPrepare(db, setHolding)
 	g.getLeaders = mustPrepare(db, getLeaders)
 	g.getAdmin = mustPrepare(db, getAdmin)
 	g.setAdmin = mustPrepare(db, setAdmin)
 	g.resetGame = mustPrepare(db, resetGame)
+	g.addPrice = mustPrepare(db, addPrice)
+	g.getPrices = mustPrepare(db, getPrices)
+	g.addLedger = mustPrepare(db, addLedger)
+	g.getLedger = mustPrepare(db, getLedger)
+	g.getDeletedLedger = mustPrepare(db, getDeletedLedger)
+	g.addLedgerCash = mustPrepare(db, addLedgerCash)
+	g.addTurn = mustPrepare(db, addTurn)
+	g.getTurns = mustPrepare(db, getTurns)
+	g.getTurnPrices = mustPrepare(db, getTurnPrices)
+	g.addOrder = mustPrepare(db, addOrder)
+	g.getOrders = mustPrepare(db, getOrders)
+	g.cancelOrder = mustPrepare(db, cancelOrder)
+	g.expireOrders = mustPrepare(db, expireOrders)
+	g.findOrders = mustPrepare(db, findOrders)
+	g.findTriggers = mustPrepare(db, findTriggers)
+	g.fillBuy = mustPrepare(db, fillBuy)
+	g.fillSell = mustPrepare(db, fillSell)
+	g.short = mustPrepare(db, shortStock)
+	g.cover = mustPrepare(db, coverStock)
+	g.getShort = mustPrepare(db, getShort)
+	g.listShorts = mustPrepare(db, listShorts)
+	g.shortValue = mustPrepare(db, shortValue)
+	g.getStockValue = mustPrepare(db, getStockValue)
+	g.getPlayerName = mustPrepare(db, getPlayerName)
+	g.addToken = mustPrepare(db, addToken)
+	g.getTokens = mustPrepare(db, getTokens)
+	g.deleteToken = mustPrepare(db, deleteToken)
+	g.findPlayerByToken = mustPrepare(db, findPlayerByToken)
+	g.addOffer = mustPrepare(db, addOffer)
+	g.matchOffers = mustPrepare(db, matchOffers)
+	g.takeOffer = mustPrepare(db, takeOffer)
+	g.withdrawOffer = mustPrepare(db, withdrawOffer)
+	g.getOffers = mustPrepare(db, getOffers)
+	g.getDepth = mustPrepare(db, getDepth)
+	g.addSeasonDate = mustPrepare(db, addSeasonDate)
+	g.getSeasonDates = mustPrepare(db, getSeasonDates)
+	g.deleteSeasonDate = mustPrepare(db, deleteSeasonDate)
+	g.addSeason = mustPrepare(db, addSeason)
+	g.addSeasonResult = mustPrepare(db, addSeasonResult)
+	g.getSeasons = mustPrepare(db, getSeasons)
+	g.getSeason = mustPrepare(db, getSeason)
+	g.getSeasonResults = mustPrepare(db, getSeasonResults)
+	g.getSeasonHoldings = mustPrepare(db, getSeasonHoldings)
+	g.getSeasonStocks = mustPrepare(db, getSeasonStocks)
+	g.getAllResults = mustPrepare(db, getAllResults)
+	g.addHistoryDated = mustPrepare(db, addHistoryDated)
+	g.getLoginFailure = mustPrepare(db, getLoginFailure)
+	g.addLoginFailure = mustPrepare(db, addLoginFailure)
+	g.setLockedUntil = mustPrepare(db, setLockedUntil)
+	g.clearLoginFailure = mustPrepare(db, clearLoginFailure)
+	g.getLockouts = mustPrepare(db, getLockouts)
+	g.addAudit = mustPrepare(db, addAudit)
+	g.getAudit = mustPrepare(db, getAudit)
 }
 
 func Open(data string) *Game {
-	rand.Seed(GetSeed())
-
 	var g Game
 
-	db, err := sql.Open("sqlite", data)
+	db, err := openGame(data)
 	if err != nil {
-		log.Fatal(err)
+		return nil
 	}
 	g.db = db
 
-	// check contents of db here
-	row := db.QueryRow(string(getGame), "Key")
-	var key []byte
-	err = row.Scan(&key)
-	if err != nil || len(key) < 10 {
+	backup, err := migrate(db, data, false)
+	if err != nil {
+		log.Println(err)
 		db.Close()
 		return nil
 	}
+	if len(backup) > 0 {
+		log.Println("Upgraded the game, after saving a backup to", backup)
+	}
 	g.prepareAll()
 
 	return &g
 }
 
 func Create(data string) *Game {
-	rand.Seed(GetSeed())
-
 	var g Game
 
 	db, err := sql.Open("sqlite", data)
@@ -539,7 +994,9 @@ func Create(data string) *Game {
 
 	g.prepareAll()
 	g.setGame.Exec("Key", newKey())
-	g.reset(nil)
+	g.setGame.Exec(schemaKey, LatestSchema())
+	g.reset(nil, g.Rules(), rand.New(rand.NewSource(g.seed())))
+	g.StartSeason(time.Now())
 
 	return &g
 }
@@ -0,0 +1,46 @@
+package state
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// testGame creates a game in a temporary file, which is removed when the
+// test ends.
+func testGame(t *testing.T) *Game {
+	t.Helper()
+	g := Create(filepath.Join(t.TempDir(), "game.db"))
+	if g == nil {
+		t.Fatal("Unable to create game")
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+// testPlayer adds a player to g.
+func testPlayer(t *testing.T, g *Game, name string) *PlayerInfo {
+	t.Helper()
+	p := g.NewPlayer(name)
+	if p == nil {
+		t.Fatal("Unable to add", name)
+	}
+	return p
+}
+
+func TestTradeLots(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0].Name
+	alice := testPlayer(t, g, "alice")
+	for _, lots := range []uint64{0, math.MaxUint64, math.MaxUint64/g.Rules().LotSize + 1} {
+		if err := alice.Buy(stock, lots); err == nil {
+			t.Errorf("Bought %d lots", lots)
+		}
+		if err := alice.Sell(stock, lots); err == nil {
+			t.Errorf("Sold %d lots", lots)
+		}
+	}
+	if l := alice.Ledger(); len(l) != 0 {
+		t.Errorf("alice's ledger is %+v", l)
+	}
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// legacyStocks is how many stocks the original comprod had.
+const legacyStocks = 6
+
+// legacyStock, legacyPlayer and legacyGame decode the game state saved by
+// the original comprod, which used gob instead of SQLite. Gob matches fields
+// by name, so fields that a particular version of comprod didn't have are
+// left empty.
+type legacyStock struct {
+	Name  string
+	Value uint64
+}
+
+type legacyPlayer struct {
+	Cash     uint64
+	Shares   [legacyStocks]uint64
+	Password []byte
+	Salt     []byte
+	Admin    bool
+}
+
+type legacyGame struct {
+	Stock   [legacyStocks]legacyStock
+	Player  map[string]*legacyPlayer
+	News    []string
+	History []string
+	Time    time.Time
+}
+
+// legacyHash is the password hash of the original comprod: SHA-1 of the
+// salt followed by the password.
+func legacyHash(salt []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(salt)
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+// ImportGob creates a new game in data from the game state saved by the
+// original comprod, which is read from r. The players keep their passwords,
+// which are still hashed with SHA-1 until each player next logs in. If the
+// import fails, the new game is removed, so that it can be tried again.
+func ImportGob(data string, r io.Reader) error {
+	var old legacyGame
+	if err := gob.NewDecoder(r).Decode(&old); err != nil {
+		return fmt.Errorf("Unable to read the comprod game: %v", err)
+	}
+
+	// Only a file made for the import may be removed if it fails
+	file := dataFile(data)
+	if len(file) > 0 {
+		if _, err := os.Stat(file); err == nil {
+			return errors.New(file + " already exists, and the import needs a new file")
+		}
+	}
+	g := Create(data)
+	if g == nil {
+		return errors.New("A game already exists in " + data)
+	}
+	err := g.importGob(&old)
+	g.Close()
+	if err != nil && len(file) > 0 {
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			os.Remove(file + suffix)
+		}
+	}
+	return err
+}
+
+func (g *Game) importGob(old *legacyGame) error {
+	var names []string
+	for name, p := range old.Player {
+		if p != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if err := g.SetRule("Stocks", strconv.Itoa(legacyStocks)); err != nil {
+		return err
+	}
+	if old.Time.IsZero() {
+		old.Time = time.Now()
+	}
+	date := old.Time.UTC().Format(sqliteDate)
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("DELETE FROM Stock; DELETE FROM Holding; DELETE FROM News"); err != nil {
+		return err
+	}
+	for k, v := range old.Stock {
+		if _, err = tx.Stmt(g.addStock).Exec(k+1, v.Name, v.Value); err != nil {
+			return fmt.Errorf("Unable to add %s: %v", v.Name, err)
+		}
+	}
+	for _, name := range names {
+		p := old.Player[name]
+		var id int
+		if err = tx.Stmt(g.addPlayer).QueryRow(name).Scan(&id); err != nil {
+			return fmt.Errorf("Unable to add %s: %v", name, err)
+		}
+		if _, err = tx.Stmt(g.setPassword).Exec(id, "sha1", p.Salt, p.Password); err != nil {
+			return err
+		}
+		if _, err = tx.Stmt(g.setAdmin).Exec(id, p.Admin); err != nil {
+			return err
+		}
+		if _, err = tx.Stmt(g.setHolding).Exec(id, "Cash", p.Cash); err != nil {
+			return err
+		}
+		for k, shares := range p.Shares {
+			if shares > 0 {
+				if _, err = tx.Stmt(g.setHolding).Exec(id, k+1, shares); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, n := range old.News {
+		if _, err = tx.Stmt(g.addNews).Exec(n); err != nil {
+			return err
+		}
+	}
+	// The original history isn't dated
+	for _, h := range old.History {
+		if _, err = tx.Stmt(g.addHistoryDated).Exec(date, h); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Stmt(g.setGame).Exec("Time", date); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
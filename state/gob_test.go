@@ -0,0 +1,78 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func legacyState(t *testing.T, stocks ...string) *bytes.Buffer {
+	t.Helper()
+	old := legacyGame{
+		Player: map[string]*legacyPlayer{
+			"alice": {Cash: 5000, Shares: [legacyStocks]uint64{100}, Salt: []byte("salt"), Admin: true},
+		},
+		News:    []string{"Gold rises"},
+		History: []string{"alice won"},
+		Time:    time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	old.Player["alice"].Password = legacyHash(old.Player["alice"].Salt, "secret")
+	for k, name := range stocks {
+		old.Stock[k] = legacyStock{name, uint64(100 + k)}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&old); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestImportGob(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "game.db")
+	if err := ImportGob(file, legacyState(t, "Gold", "Silver", "Oil", "Bonds", "Grain", "Industrial")); err != nil {
+		t.Fatal(err)
+	}
+	g := Open(file)
+	if g == nil {
+		t.Fatal("Unable to open the imported game")
+	}
+	defer g.Close()
+	if s := g.ListStocks(); len(s) != legacyStocks || s[0].Name != "Gold" {
+		t.Errorf("Stocks are %+v", s)
+	}
+	alice := g.Player("alice")
+	if alice == nil || !alice.IsAdmin() {
+		t.Fatal("alice wasn't imported")
+	}
+	if h := alice.Holdings(); h.Cash != 5000 || h.Shares["Gold"] != 100 {
+		t.Errorf("alice holds %+v", h)
+	}
+	if ok, err := alice.CheckPassword("secret"); !ok || err != nil {
+		t.Errorf("alice's password doesn't work: %v", err)
+	}
+
+	// A second import mustn't touch the game
+	if err := ImportGob(file, legacyState(t, "Gold")); err == nil {
+		t.Error("Imported over an existing game")
+	}
+	if g.Player("alice") == nil {
+		t.Error("The second import removed alice")
+	}
+}
+
+func TestImportGobFails(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "game.db")
+	if err := ImportGob(file, legacyState(t, "Gold", "Gold")); err == nil {
+		t.Fatal("Imported two stocks with the same name")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("The failed import left %s behind: %v", file, err)
+	}
+	// The import can be tried again once the problem is fixed
+	if err := ImportGob(file, legacyState(t, "Gold", "Silver", "Oil", "Bonds", "Grain", "Industrial")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package state
+
+import "testing"
+
+func TestLedgerSeasonReset(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0]
+	lot := g.Rules().LotSize
+	alice := testPlayer(t, g, "alice")
+	if err := alice.Buy(stock.Name, 2); err != nil {
+		t.Fatal(err)
+	}
+	g.EndSeason()
+
+	var shares, change int64
+	for _, v := range alice.Ledger() {
+		if v.Reason != "season reset" {
+			continue
+		}
+		if v.Stock == stock.Name {
+			shares += v.Shares
+		} else if v.Stock == "Cash" {
+			change += v.Cash
+		}
+	}
+	if shares != -2*int64(lot) {
+		t.Errorf("The season reset took %d shares, want %d", -shares, 2*lot)
+	}
+	if want := int64(2 * lot * stock.Value); change != want {
+		t.Errorf("The season reset changed cash by $%d, want $%d", change, want)
+	}
+}
+
+func TestLedgerDeletedPlayer(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0].Name
+	alice := testPlayer(t, g, "alice")
+	if err := alice.Buy(stock, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := len(alice.Ledger())
+	if !g.DeletePlayer("alice") {
+		t.Fatal("Unable to delete alice")
+	}
+	if got := len(g.DeletedLedger("alice")); got != want {
+		t.Errorf("The deleted alice's ledger has %d entries, want %d", got, want)
+	}
+
+	// A new player doesn't inherit the deleted player's ledger
+	bob := testPlayer(t, g, "bob")
+	if got := len(bob.Ledger()); got != 0 {
+		t.Errorf("bob's ledger has %d entries", got)
+	}
+	alice = testPlayer(t, g, "alice")
+	if got := len(alice.Ledger()); got != 0 {
+		t.Errorf("The new alice's ledger has %d entries", got)
+	}
+	if got := len(g.DeletedLedger("alice")); got != want {
+		t.Errorf("The deleted alice's ledger has %d entries, want %d", got, want)
+	}
+}
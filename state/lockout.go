@@ -0,0 +1,206 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Failed logins are counted both for each account and for each address.
+// After a few failures, each further attempt is delayed by a backoff that
+// doubles with every failure, and after lockAt failures, the account or
+// address is locked out for lockFor. Counts are forgotten a day after the
+// last failure, and an account's count is cleared when it logs in.
+type lockPolicy struct {
+	kind    string
+	free    int
+	lockAt  int
+	backoff time.Duration
+	lockFor time.Duration
+}
+
+var (
+	accountPolicy = lockPolicy{"account", 5, 10, 5 * time.Second, time.Hour}
+	addressPolicy = lockPolicy{"address", 20, 50, 5 * time.Second, time.Hour}
+)
+
+// delay returns how long after the last of failures another attempt may be
+// made.
+func (lp lockPolicy) delay(failures int) time.Duration {
+	if failures >= lp.lockAt {
+		return lp.lockFor
+	}
+	if failures <= lp.free {
+		return 0
+	}
+	d := lp.backoff << (failures - lp.free - 1)
+	if d > lp.lockFor {
+		d = lp.lockFor
+	}
+	return d
+}
+
+// Lockout is an account or address that may not log in until Until.
+type Lockout struct {
+	Kind     string
+	Subject  string
+	Failures int
+	Until    time.Time
+}
+
+// AuditEntry is an event in the audit log, such as a lockout.
+type AuditEntry struct {
+	Date    time.Time
+	Event   string
+	Subject string
+	Detail  string
+}
+
+func (g *Game) loginFailures(lp lockPolicy, subject string) (int, time.Time) {
+	var failures int
+	var until string
+	err := retryBusy(func() error {
+		return g.getLoginFailure.QueryRow(lp.kind, subject).Scan(&failures, &until)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	t, _ := time.Parse(sqliteDate, until)
+	return failures, t
+}
+
+// LoginAllowed reports whether the named player may try to log in from the
+// address ip, and if not, when they may next try.
+func (g *Game) LoginAllowed(name, ip string) (time.Time, bool) {
+	var rv time.Time
+	if _, until := g.loginFailures(accountPolicy, name); until.After(rv) {
+		rv = until
+	}
+	if _, until := g.loginFailures(addressPolicy, ip); until.After(rv) {
+		rv = until
+	}
+	return rv, !rv.After(time.Now())
+}
+
+// LoginFailed records a failed attempt to log in as the named player from
+// the address ip.
+func (g *Game) LoginFailed(name, ip string) {
+	g.loginFailed(accountPolicy, name)
+	g.loginFailed(addressPolicy, ip)
+}
+
+// retryBusy calls f until the database isn't too busy for it. Each of the
+// statements f runs stands alone, so a busy one holds no locks that would
+// keep the others waiting.
+func retryBusy(f func() error) error {
+	for {
+		if err := f(); !isBusy(err) {
+			return err
+		}
+	}
+}
+
+func (g *Game) loginFailed(lp lockPolicy, subject string) {
+	// The count is kept in a single statement, so that concurrent failures
+	// each count. A lockout only ever gets longer, whichever order the
+	// failures set it in.
+	var failures int
+	err := retryBusy(func() error {
+		return g.addLoginFailure.QueryRow(lp.kind, subject).Scan(&failures)
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	delay := lp.delay(failures)
+	until := time.Now().UTC().Add(delay).Format(sqliteDate)
+	err = retryBusy(func() error {
+		_, err := g.setLockedUntil.Exec(lp.kind, subject, until)
+		return err
+	})
+	if err == nil && failures >= lp.lockAt {
+		err = g.audit("lockout", subject, fmt.Sprintf("%s locked for %v after %d failed logins", lp.kind, delay, failures))
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// LoginSucceeded clears the failed logins of the named player. Failures from
+// the address they logged in from still count against it.
+func (g *Game) LoginSucceeded(name string) {
+	err := retryBusy(func() error {
+		_, err := g.clearLoginFailure.Exec(accountPolicy.kind, name)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Lockouts returns the accounts and addresses that are currently locked out
+// or waiting for a backoff, the longest first.
+func (g *Game) Lockouts() []Lockout {
+	rv := make([]Lockout, 0)
+	r, err := g.getLockouts.Query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var l Lockout
+		var until string
+		r.Scan(&l.Kind, &l.Subject, &l.Failures, &until)
+		l.Until, _ = time.Parse(sqliteDate, until)
+		rv = append(rv, l)
+	}
+	return rv
+}
+
+// Unlock clears the failed logins of an account (if kind is "account") or
+// an address (if kind is "address"), and records who did so in the audit
+// log.
+func (g *Game) Unlock(kind, subject, by string) error {
+	if kind != accountPolicy.kind && kind != addressPolicy.kind {
+		return errors.New("Unknown kind of lockout " + kind)
+	}
+	var failures int
+	err := retryBusy(func() error {
+		return g.clearLoginFailure.QueryRow(kind, subject).Scan(&failures)
+	})
+	if err == sql.ErrNoRows {
+		return errors.New("No failed logins for " + subject)
+	}
+	if err != nil {
+		return err
+	}
+	return g.audit("unlock", subject, fmt.Sprintf("%s unlocked by %s after %d failed logins", kind, by, failures))
+}
+
+// audit adds an entry to the audit log.
+func (g *Game) audit(event, subject, detail string) error {
+	return retryBusy(func() error {
+		_, err := g.addAudit.Exec(event, subject, detail)
+		return err
+	})
+}
+
+// AuditLog returns the latest n entries in the audit log, the latest first.
+func (g *Game) AuditLog(n int) []AuditEntry {
+	rv := make([]AuditEntry, 0)
+	r, err := g.getAudit.Query(n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var e AuditEntry
+		var date string
+		r.Scan(&date, &e.Event, &e.Subject, &e.Detail)
+		e.Date, _ = time.Parse(sqliteDate, date)
+		rv = append(rv, e)
+	}
+	return rv
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginFailedConcurrently(t *testing.T) {
+	g := testGame(t)
+
+	// Hold the write lock for a moment, as a turn would
+	ctx := context.Background()
+	conn, err := g.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		t.Fatal(err)
+	}
+	committed := make(chan bool)
+	time.AfterFunc(100*time.Millisecond, func() {
+		retryBusy(func() error {
+			_, err := conn.ExecContext(ctx, "COMMIT")
+			return err
+		})
+		conn.Close()
+		close(committed)
+	})
+
+	n := accountPolicy.lockAt
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g.LoginFailed("alice", fmt.Sprintf("10.0.0.%d", i))
+		}(i)
+	}
+	wg.Wait()
+	<-committed
+
+	if failures, _ := g.loginFailures(accountPolicy, "alice"); failures != n {
+		t.Errorf("alice has %d failed logins, want %d", failures, n)
+	}
+	if until, ok := g.LoginAllowed("alice", "10.0.1.1"); ok || until.Before(time.Now().Add(accountPolicy.lockFor-time.Minute)) {
+		t.Errorf("alice may log in at %s", until)
+	}
+	if _, ok := g.LoginAllowed("bob", "10.0.0.1"); !ok {
+		t.Errorf("bob may not log in")
+	}
+	log := g.AuditLog(10)
+	if len(log) != 1 || log[0].Event != "lockout" || log[0].Subject != "alice" {
+		t.Errorf("The audit log is %+v", log)
+	}
+
+	if err = g.Unlock("account", "alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.LoginAllowed("alice", "10.0.1.1"); !ok {
+		t.Errorf("alice may not log in after being unlocked")
+	}
+	if err = g.Unlock("account", "alice", "admin"); err == nil {
+		t.Errorf("alice was unlocked twice")
+	}
+}
+
+func TestLockPolicyDelay(t *testing.T) {
+	lp := lockPolicy{"test", 2, 6, time.Second, time.Minute}
+	for failures, want := range []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, time.Minute, time.Minute} {
+		if got := lp.delay(failures); got != want {
+			t.Errorf("After %d failures, the delay is %v, want %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+type MoveKind int
+
+const (
+	// Rise increases the price of the stock by Amount
+	Rise MoveKind = iota
+	// Fall decreases the price of the stock by Amount. A fall to $0 or
+	// below is a bankruptcy.
+	Fall
+	// Dividend pays Amount per share to the holders of the stock
+	Dividend
+	// Split splits the stock 2 for 1
+	Split
+	// Bankrupt removes the stock from the market, and lists a new stock
+	Bankrupt
+	// News adds News to the day's news
+	News
+)
+
+// Move is a single change to the market. Stock is the index of the stock in
+// the list passed to MarketModel.Round.
+type Move struct {
+	Stock  int
+	Kind   MoveKind
+	Amount uint64
+	News   string
+}
+
+// MarketModel decides how the market moves. Each day's market adjustments
+// are made in a number of rounds; after each round, standing orders and
+// margin calls are processed at the new prices.
+type MarketModel interface {
+	// Round returns the moves for a single round, given the current stock
+	// prices and the rules of the game. All randomness must come from rng.
+	Round(stocks []Stock, rules *Rules, rng *rand.Rand) []Move
+}
+
+var marketModels = map[string]MarketModel{
+	"classic": classicModel{},
+	"gbm":     gbmModel{},
+}
+
+const defaultModel = "classic"
+
+// MarketModels returns the names of the available market models.
+func MarketModels() []string {
+	rv := make([]string, 0, len(marketModels))
+	for k := range marketModels {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// MarketModel returns the name of the market model used by the game.
+func (g *Game) MarketModel() string {
+	r := g.getGame.QueryRow("Model")
+	rv := defaultModel
+	r.Scan(&rv)
+	if _, ok := marketModels[rv]; !ok {
+		return defaultModel
+	}
+	return rv
+}
+
+func (g *Game) SetMarketModel(name string) error {
+	if _, ok := marketModels[name]; !ok {
+		return errors.New("Unknown market model: " + name)
+	}
+	_, err := g.setGame.Exec("Model", name)
+	return err
+}
+
+// classicModel is the original comprod market. Each round, a random stock
+// rises, falls, or pays a dividend.
+type classicModel struct{}
+
+func (classicModel) Round(stocks []Stock, rules *Rules, rng *rand.Rand) []Move {
+	const (
+		up = iota
+		down
+		dividend
+	)
+
+	adjust := uint64(math.Pow(rng.Float64()*.8+1.2, 5.0))
+	stock := rng.Intn(len(stocks))
+	value := stocks[stock].Value
+	switch rng.Intn(3) {
+	case up:
+		if value+adjust >= rules.SplitValue {
+			return []Move{{Stock: stock, Kind: Rise, Amount: adjust}, {Stock: stock, Kind: Split}}
+		}
+		return []Move{{Stock: stock, Kind: Rise, Amount: adjust}}
+	case down:
+		if value <= adjust {
+			return []Move{{Stock: stock, Kind: Bankrupt}}
+		}
+		return []Move{{Stock: stock, Kind: Fall, Amount: adjust}}
+	default: // case dividend:
+		if value >= rules.StartingValue {
+			return []Move{{Stock: stock, Kind: Dividend, Amount: adjust}}
+		}
+	}
+	return nil
+}
+
+// Daily volatility of each commodity in the geometric Brownian motion model.
+var volatility = map[string]float64{
+	"Coffee":      0.20,
+	"Soybeans":    0.12,
+	"Corn":        0.12,
+	"Wheat":       0.14,
+	"Cocoa":       0.20,
+	"Gold":        0.08,
+	"Silver":      0.14,
+	"Platinum":    0.12,
+	"Oil":         0.22,
+	"Natural Gas": 0.28,
+	"Cotton":      0.14,
+	"Sugar":       0.18,
+	"Lithium":     0.30,
+	"Cobalt":      0.26,
+}
+
+const defaultVolatility = 0.15
+
+// gbmModel moves every stock every round, using geometric Brownian motion
+// with no drift and a volatility which depends on the commodity. Stocks at
+// or above the starting value occasionally pay a dividend of 2% of their
+// price.
+type gbmModel struct{}
+
+func (gbmModel) Round(stocks []Stock, rules *Rules, rng *rand.Rand) []Move {
+	dt := 1.0 / float64(rules.Rounds)
+	var moves []Move
+	for k, v := range stocks {
+		sigma, ok := volatility[v.Name]
+		if !ok {
+			sigma = defaultVolatility
+		}
+		z := rng.NormFloat64()
+		next := math.Round(float64(v.Value) * math.Exp(-sigma*sigma/2*dt+sigma*math.Sqrt(dt)*z))
+		switch {
+		case next < 1:
+			moves = append(moves, Move{Stock: k, Kind: Bankrupt})
+			continue
+		case uint64(next) > v.Value:
+			moves = append(moves, Move{Stock: k, Kind: Rise, Amount: uint64(next) - v.Value})
+		case uint64(next) < v.Value:
+			moves = append(moves, Move{Stock: k, Kind: Fall, Amount: v.Value - uint64(next)})
+		}
+		if z > 3 || z < -3 {
+			moves = append(moves, Move{Stock: k, Kind: News, News: fmt.Sprintf("Heavy trading in %s", v.Name)})
+		}
+		if uint64(next) >= rules.StartingValue && rng.Intn(3*len(stocks)) == 0 {
+			moves = append(moves, Move{Stock: k, Kind: Dividend, Amount: uint64(next) / 50})
+		}
+		// The dividend is paid on the shares held before any split, since
+		// it was worked out from the price before the split
+		if uint64(next) > v.Value && uint64(next) >= rules.SplitValue {
+			moves = append(moves, Move{Stock: k, Kind: Split})
+		}
+	}
+	return moves
+}
@@ -0,0 +1,37 @@
+package state
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGBMDividendBeforeSplit(t *testing.T) {
+	rules := Rules{StartingValue: 100, SplitValue: 200, Rounds: 1}
+	stocks := make([]Stock, 14)
+	for k := range stocks {
+		stocks[k] = Stock{Name: "Lithium", Value: 199}
+	}
+	rng := rand.New(rand.NewSource(1))
+	both := 0
+	for i := 0; i < 1000; i++ {
+		split := make(map[int]bool)
+		paid := make(map[int]bool)
+		for _, m := range (gbmModel{}).Round(stocks, &rules, rng) {
+			switch m.Kind {
+			case Split:
+				split[m.Stock] = true
+				if paid[m.Stock] {
+					both++
+				}
+			case Dividend:
+				if split[m.Stock] {
+					t.Fatalf("Dividend on stock %d follows its split", m.Stock)
+				}
+				paid[m.Stock] = true
+			}
+		}
+	}
+	if both == 0 {
+		t.Fatal("No stock paid a dividend and split in the same round")
+	}
+}
@@ -0,0 +1,179 @@
+package state
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The migrations bring the schema of an older game up to date. Each one is
+// a file named for the schema version it upgrades the game to, followed by
+// a short description, such as "0002-indexes". A new game is created with
+// the latest schema (in sql/create), so every change to the schema needs
+// both a migration and a change to sql/create.
+//
+//go:embed sql/migrate
+var migrateFS embed.FS
+
+// schemaKey is the key in the Game table that holds the schema version.
+// Games from before schema versions have none, which is version 0.
+const schemaKey = "schema_version"
+
+// Migration is one step in upgrading the schema of a game.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func migrations() []Migration {
+	var rv []Migration
+	files, err := fs.ReadDir(migrateFS, "sql/migrate")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		number, name, _ := strings.Cut(f.Name(), "-")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			panic("Badly named migration " + f.Name())
+		}
+		body, _ := migrateFS.ReadFile(path.Join("sql/migrate", f.Name()))
+		rv = append(rv, Migration{version, name, string(body)})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Version < rv[j].Version })
+	return rv
+}
+
+// LatestSchema returns the schema version of a newly created game.
+func LatestSchema() int {
+	m := migrations()
+	return m[len(m)-1].Version
+}
+
+func schemaVersion(db *sql.DB) int {
+	var rv int
+	db.QueryRow(getGame, schemaKey).Scan(&rv)
+	return rv
+}
+
+// pending returns the schema version of the game in db, and the migrations
+// that it still needs.
+func pending(db *sql.DB) (int, []Migration, error) {
+	version := schemaVersion(db)
+	latest := LatestSchema()
+	if version > latest {
+		return version, nil, fmt.Errorf("The game has schema version %d, but this version of comprod2 only understands up to version %d", version, latest)
+	}
+	var rv []Migration
+	for _, m := range migrations() {
+		if m.Version > version {
+			rv = append(rv, m)
+		}
+	}
+	return version, rv, nil
+}
+
+// dataFile returns the name of the file that holds the game in data, or ""
+// if data isn't a file.
+func dataFile(data string) string {
+	file := strings.TrimPrefix(data, "file:")
+	file, query, _ := strings.Cut(file, "?")
+	if len(file) < 1 || file == ":memory:" || strings.Contains(query, "mode=memory") {
+		return ""
+	}
+	return file
+}
+
+// backupName returns the name of the file to back the game in data up to
+// before migrating it from version, or "" if data isn't a file.
+func backupName(data string, version int) string {
+	file := dataFile(data)
+	if len(file) < 1 {
+		return ""
+	}
+	return fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().UTC().Format("20060102T150405"))
+}
+
+// migrate applies the pending migrations to the game in db, which was opened
+// from data, in a single transaction. A backup of the game is made first.
+// If dryRun is set, the migrations are tried, then rolled back, and no
+// backup is made. migrate returns the name of the backup.
+func migrate(db *sql.DB, data string, dryRun bool) (string, error) {
+	version, todo, err := pending(db)
+	if err != nil || len(todo) < 1 {
+		return "", err
+	}
+
+	backup := ""
+	if !dryRun {
+		backup = backupName(data, version)
+		if len(backup) > 0 {
+			if _, err = db.Exec("VACUUM INTO ?1", backup); err != nil {
+				return "", fmt.Errorf("Unable to back the game up to %s: %v", backup, err)
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return backup, err
+	}
+	defer tx.Rollback()
+	for _, m := range todo {
+		if _, err = tx.Exec(m.SQL); err != nil {
+			return backup, fmt.Errorf("Migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		if _, err = tx.Exec(setGame, schemaKey, m.Version); err != nil {
+			return backup, err
+		}
+	}
+	if dryRun {
+		return "", nil
+	}
+	return backup, tx.Commit()
+}
+
+func openGame(data string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", data)
+	if err != nil {
+		return nil, err
+	}
+	row := db.QueryRow(getGame, "Key")
+	var key []byte
+	err = row.Scan(&key)
+	if err != nil || len(key) < 10 {
+		db.Close()
+		return nil, fmt.Errorf("%s doesn't hold a game", data)
+	}
+	return db, nil
+}
+
+// PendingMigrations returns the schema version of the game in data, and the
+// migrations it needs to bring it up to date.
+func PendingMigrations(data string) (int, []Migration, error) {
+	db, err := openGame(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+	return pending(db)
+}
+
+// Migrate brings the schema of the game in data up to date, as Open does,
+// and returns the name of the backup made first (if any). If dryRun is set,
+// the migrations are tried, and then rolled back.
+func Migrate(data string, dryRun bool) (string, error) {
+	db, err := openGame(data)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	return migrate(db, data, dryRun)
+}
@@ -0,0 +1,83 @@
+package state
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// baselineGame is a game as created before schema versions, with one player
+// holding some shares and cash.
+const baselineGame = `
+CREATE TABLE Game (Key TEXT UNIQUE, Value ANY);
+CREATE TABLE Player (PlayerID INTEGER PRIMARY KEY, Name TEXT UNIQUE, Admin INTEGER DEFAULT FALSE, PWHash TEXT, Password BLOB, Salt BLOB, Cookie BLOB UNIQUE);
+CREATE TABLE Stock (StockID INTEGER PRIMARY KEY, Name TEXT UNIQUE, Value INTEGER);
+CREATE TABLE Holding (PlayerID INTEGER, Stock TEXT, Value INTEGER, CONSTRAINT ownership UNIQUE (PlayerID, Stock));
+CREATE TABLE News (NewsID INTEGER PRIMARY KEY, Text TEXT);
+CREATE TABLE History (Date TEXT, Text TEXT);
+INSERT INTO Game (Key, Value) VALUES ('Time', datetime());
+INSERT INTO Game (Key, Value) VALUES ('Key', X'00112233445566778899aabbccddeeff');
+INSERT INTO Player (PlayerID, Name, Admin) VALUES (1, 'alice', TRUE);
+INSERT INTO Stock (StockID, Name, Value) VALUES (1, 'Gold', 120), (2, 'Oil', 80);
+INSERT INTO Holding (PlayerID, Stock, Value) VALUES (1, 1, 300), (1, 'Cash', 5000);
+`
+
+func TestMigrateBaseline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "game.db")
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(baselineGame); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	g := Open(file)
+	if g == nil {
+		t.Fatal("Unable to open the baseline game")
+	}
+	if v := schemaVersion(g.db); v != LatestSchema() {
+		t.Errorf("Schema version is %d, not %d", v, LatestSchema())
+	}
+	if backups, _ := filepath.Glob(file + ".v0-*.bak"); len(backups) != 1 {
+		t.Errorf("Backups are %v", backups)
+	}
+
+	alice := g.Player("alice")
+	if alice == nil || !alice.IsAdmin() {
+		t.Fatal("alice didn't survive the migration")
+	}
+	h := alice.Holdings()
+	if h.Cash != 5000 || h.Shares["Gold"] != 300 {
+		t.Errorf("alice holds %+v", h)
+	}
+	if err = alice.Sell("Gold", 1); err != nil {
+		t.Fatal(err)
+	}
+	if l := alice.Ledger(); len(l) != 1 || l[0].Shares != -100 {
+		t.Errorf("alice's ledger is %+v", l)
+	}
+	if len(g.Lockouts()) != 0 {
+		t.Error("The migrated game has lockouts")
+	}
+	g.Close()
+
+	// The game is up to date, so opening it again needs no backup
+	g = Open(file)
+	if g == nil {
+		t.Fatal("Unable to reopen the migrated game")
+	}
+	g.Close()
+	if backups, _ := filepath.Glob(file + ".v*.bak"); len(backups) != 1 {
+		t.Errorf("Backups are %v", backups)
+	}
+}
+
+func TestOpenNotAGame(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "empty.db")
+	if g := Open(file); g != nil {
+		g.Close()
+		t.Fatal("Opened a file without a game")
+	}
+}
@@ -0,0 +1,209 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Order is a standing order. A "buy" limit order fills when the price falls
+// to Limit or below, and a "sell" limit order fills when the price rises to
+// Limit or above. A "stop" (stop-loss) order sells when the price falls to
+// Limit or below, and a "take" (take-profit) order sells when the price rises
+// to Limit or above; both sell at Limit rather than at the market price.
+// Orders are only filled during the daily market adjustments.
+type Order struct {
+	ID      int64
+	Stock   string
+	Action  string
+	Shares  uint64
+	Limit   uint64
+	Expires time.Time
+}
+
+func (p *PlayerInfo) PlaceOrder(stock, action string, lots, limit uint64, expires time.Time) error {
+	if lots < 1 {
+		return errors.New("An order must be for at least one board lot")
+	}
+	if limit < 1 {
+		return errors.New("The limit price must be at least $1")
+	}
+	switch action {
+	case "buy", "sell", "stop", "take":
+	default:
+		return errors.New("Unrecognized order: " + action)
+	}
+	shares := lots * p.g.Rules().LotSize
+	for {
+		tx, err := p.g.db.Begin()
+		if err != nil {
+			return err
+		}
+		idx := p.g.findStock(tx, stock)
+		if idx < 0 {
+			tx.Rollback()
+			return fmt.Errorf("%s is not on the market", stock)
+		}
+		if action == "stop" || action == "take" {
+			var value uint64
+			err = tx.Stmt(p.g.getStockValue).QueryRow(idx).Scan(&value)
+			if isBusy(err) {
+				tx.Rollback()
+				continue
+			}
+			if err == nil && action == "stop" && limit >= value {
+				err = fmt.Errorf("A stop-loss must be below the current price of %s", stock)
+			}
+			if err == nil && action == "take" && limit <= value {
+				err = fmt.Errorf("A take-profit must be above the current price of %s", stock)
+			}
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		_, err = tx.Stmt(p.g.addOrder).Exec(p.playerID, idx, action, shares, limit, expires.UTC().Format(sqliteDate))
+		if err == nil {
+			err = tx.Commit()
+		}
+		if isBusy(err) {
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+		return err
+	}
+}
+
+func (p *PlayerInfo) Orders() []Order {
+	rv := make([]Order, 0)
+	r, err := p.g.getOrders.Query(p.playerID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var o Order
+		var expires string
+		r.Scan(&o.ID, &o.Stock, &o.Action, &o.Shares, &o.Limit, &expires)
+		o.Expires, _ = time.Parse(sqliteDate, expires)
+		rv = append(rv, o)
+	}
+	return rv
+}
+
+func (p *PlayerInfo) CancelOrder(id int64) error {
+	r := p.g.cancelOrder.QueryRow(id, p.playerID)
+	err := r.Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("No such order")
+	}
+	return err
+}
+
+// fillOrders fills every order for stock that will trade at price, as long
+// as the player still has the cash or shares needed to fill it. Orders which
+// can't be filled remain open.
+func (g *Game) fillOrders(tx *sql.Tx, stock int, price uint64) {
+	type fill struct {
+		id     int64
+		player int
+		action string
+		shares uint64
+	}
+	var fills []fill
+	r, err := tx.Stmt(g.findOrders).Query(stock+1, price)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for r.Next() {
+		var f fill
+		r.Scan(&f.id, &f.player, &f.action, &f.shares)
+		fills = append(fills, f)
+	}
+	r.Close()
+
+	for _, f := range fills {
+		var have uint64
+		delta := int64(f.shares)
+		switch f.action {
+		case "buy":
+			tx.Stmt(g.getHolding).QueryRow(f.player, "Cash").Scan(&have)
+			if have < f.shares*price {
+				continue
+			}
+			// The cash left over must still cover the player's short
+			// positions, as it must for any other purchase
+			var short uint64
+			tx.Stmt(g.shortValue).QueryRow(f.player).Scan(&short)
+			if (have-f.shares*price)*100 < short*shortMargin {
+				continue
+			}
+			tx.Stmt(g.fillBuy).Exec(f.player, stock+1, f.shares, price)
+		case "sell":
+			tx.Stmt(g.getHolding).QueryRow(f.player, stock+1).Scan(&have)
+			if have < f.shares {
+				continue
+			}
+			tx.Stmt(g.fillSell).Exec(f.player, stock+1, f.shares, price)
+			delta = -delta
+		default:
+			continue
+		}
+		tx.Stmt(g.addLedger).Exec(f.player, stock+1, delta, price, "limit "+f.action)
+		tx.Stmt(g.cancelOrder).Exec(f.id, f.player)
+	}
+}
+
+// fireTriggers sells the shares covered by every stop-loss and take-profit
+// order for stock (named name) that price has crossed. Shares are sold at the trigger
+// price, so this must be called before a split or bankruptcy is applied.
+// It returns news items describing each order that fired.
+func (g *Game) fireTriggers(tx *sql.Tx, stock int, name string, price uint64) []string {
+	type trigger struct {
+		id     int64
+		player int
+		name   string
+		action string
+		shares uint64
+		price  uint64
+	}
+	var triggers []trigger
+	r, err := tx.Stmt(g.findTriggers).Query(stock+1, price)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	for r.Next() {
+		var t trigger
+		r.Scan(&t.id, &t.player, &t.name, &t.action, &t.shares, &t.price)
+		triggers = append(triggers, t)
+	}
+	r.Close()
+
+	var news []string
+	for _, t := range triggers {
+		tx.Stmt(g.cancelOrder).Exec(t.id, t.player)
+		var have uint64
+		tx.Stmt(g.getHolding).QueryRow(t.player, stock+1).Scan(&have)
+		if have < t.shares {
+			t.shares = have
+		}
+		if t.shares == 0 {
+			continue
+		}
+		tx.Stmt(g.fillSell).Exec(t.player, stock+1, t.shares, t.price)
+		kind := "take-profit"
+		if t.action == "stop" {
+			kind = "stop-loss"
+		}
+		tx.Stmt(g.addLedger).Exec(t.player, stock+1, -int64(t.shares), t.price, kind)
+		news = append(news, fmt.Sprintf("%s's %s sold %d shares of %s at $%d", t.name, kind, t.shares, name, t.price))
+	}
+	return news
+}
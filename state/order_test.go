@@ -0,0 +1,81 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFillOrdersMargin(t *testing.T) {
+	g := testGame(t)
+	stocks := g.ListStocks()
+	short, buy := stocks[0], stocks[1]
+	lot := g.Rules().LotSize
+	cash := g.Rules().StartingCash
+
+	// Each player shorts enough that only some of their cash may be spent
+	const shortLots = 4
+	value := shortLots * lot * short.Value
+	spare := cash + value - value*shortMargin/100
+	lots := spare / (lot * buy.Value)
+	if (lots+1)*lot*buy.Value > cash+value {
+		t.Fatal("The test needs more starting cash")
+	}
+
+	expires := time.Now().Add(time.Hour)
+	alice := testPlayer(t, g, "alice")
+	bob := testPlayer(t, g, "bob")
+	for _, p := range []struct {
+		p    *PlayerInfo
+		lots uint64
+	}{{alice, lots}, {bob, lots + 1}} {
+		if err := p.p.Short(short.Name, shortLots); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.p.PlaceOrder(buy.Name, "buy", p.lots, buy.Value, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.fillOrders(tx, 1, buy.Value)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := alice.Holdings(); h.Shares[buy.Name] != lots*lot || len(alice.Orders()) != 0 {
+		t.Errorf("alice's order wasn't filled: %+v", h)
+	}
+	if h := bob.Holdings(); h.Shares[buy.Name] != 0 || h.Cash != cash+value || len(bob.Orders()) != 1 {
+		t.Errorf("bob's order was filled without the margin: %+v", h)
+	}
+}
+
+func TestPlaceTrigger(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0]
+	alice := testPlayer(t, g, "alice")
+	expires := time.Now().Add(time.Hour)
+	for _, v := range []struct {
+		stock  string
+		action string
+		limit  uint64
+		ok     bool
+	}{
+		{stock.Name, "stop", stock.Value - 1, true},
+		{stock.Name, "stop", stock.Value, false},
+		{stock.Name, "take", stock.Value + 1, true},
+		{stock.Name, "take", stock.Value, false},
+		{"Unobtainium", "stop", 1, false},
+	} {
+		err := alice.PlaceOrder(v.stock, v.action, 1, v.limit, expires)
+		if (err == nil) != v.ok {
+			t.Errorf("%s %s at $%d: %v", v.action, v.stock, v.limit, err)
+		}
+	}
+	if o := alice.Orders(); len(o) != 2 {
+		t.Errorf("alice's orders are %+v", o)
+	}
+}
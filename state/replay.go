@@ -0,0 +1,74 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ReplayTurn is a turn which has been recomputed from its recorded seed.
+// Only the rules which affect the market are recorded in Rules, and Rounds
+// is the number of the day's rounds that the turn played.
+// Close holds the replayed closing prices, and Recorded holds the closing
+// prices which were recorded when the turn was played. News is the
+// replayed market news; news about standing orders and margin calls depends
+// on the players, and is not replayed.
+type ReplayTurn struct {
+	Date     time.Time
+	Seed     int64
+	Model    string
+	Rules    Rules
+	Rounds   uint64
+	Open     []Stock
+	Close    []Stock
+	Recorded []Stock
+	News     []string
+}
+
+// Replay recomputes the market adjustments of every turn whose date begins
+// with date (for example, "2022-04-01"), without changing the game.
+func (g *Game) Replay(date string) ([]ReplayTurn, error) {
+	var turns []ReplayTurn
+	r, err := g.getTurns.Query(date)
+	if err != nil {
+		return nil, err
+	}
+	for r.Next() {
+		var t ReplayTurn
+		var tdate string
+		r.Scan(&tdate, &t.Seed, &t.Model, &t.Rules.Rounds, &t.Rules.StartingValue, &t.Rules.SplitValue, &t.Rounds)
+		t.Date, _ = time.Parse(sqliteDate, tdate)
+		turns = append(turns, t)
+	}
+	r.Close()
+	if len(turns) < 1 {
+		return nil, fmt.Errorf("No turns were recorded on %s", date)
+	}
+
+	for k := range turns {
+		t := &turns[k]
+		model, ok := marketModels[t.Model]
+		if !ok {
+			return nil, errors.New("Unknown market model: " + t.Model)
+		}
+		tdate := t.Date.Format(sqliteDate)
+		// Only a bankruptcy is followed by another price for the same
+		// listing, so the first price after a price which isn't a
+		// bankruptcy opens the next listing.
+		bankrupt := false
+		for _, v := range g.Prices(t.Date) {
+			if !bankrupt {
+				t.Open = append(t.Open, Stock{Name: v.Stock, Value: v.Open})
+				t.Recorded = append(t.Recorded, Stock{})
+			}
+			t.Recorded[len(t.Recorded)-1] = Stock{Name: v.Stock, Value: v.Close}
+			bankrupt = v.Bankrupt
+		}
+		if len(t.Open) < 1 {
+			return nil, fmt.Errorf("No prices were recorded on %s", tdate)
+		}
+		t.Close, t.News = g.marketDay(nil, t.Open, t.Rules, t.Rounds, model, rand.New(rand.NewSource(t.Seed)), tdate)
+	}
+	return turns, nil
+}
@@ -0,0 +1,190 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Rules are the rules of the game. They are stored in the Game table, and
+// can be changed at any time. Changes to Stocks, StartingValue and
+// StartingCash take effect at the start of the next season.
+type Rules struct {
+	// Stocks is the number of stocks listed on the market
+	Stocks uint64
+	// StartingValue is the price of a newly listed stock. Stocks at or
+	// above this price may pay dividends.
+	StartingValue uint64
+	// SplitValue is the price at which a stock splits 2 for 1
+	SplitValue uint64
+	// StartingCash is the cash each player starts the season with
+	StartingCash uint64
+	// LotSize is the number of shares in a board lot
+	LotSize uint64
+	// Rounds is the number of rounds of market adjustments each day
+	Rounds uint64
+}
+
+// Settings tune the server rather than the game, so players never see
+// them. They are stored in the Game table alongside the rules, and are
+// changed the same way.
+type Settings struct {
+	// SessionIdleHours is how long a login lasts without being used
+	SessionIdleHours uint64
+	// SessionDays is the longest a login lasts, however often it is used
+	SessionDays uint64
+	// ArgonTime, ArgonMemory (in KiB) and ArgonThreads are the parameters
+	// of argon2id for new password hashes. Older hashes are upgraded
+	// when their players next log in.
+	ArgonTime    uint64
+	ArgonMemory  uint64
+	ArgonThreads uint64
+	// HashMemory is the most memory (in MiB) used to hash passwords at
+	// once. Logins beyond that wait their turn. If it is 0, a share of
+	// the server's memory is used instead.
+	HashMemory uint64
+}
+
+// ruleValues holds both the rules and the settings, so that they can be
+// listed, checked and changed together.
+type ruleValues struct {
+	Rules
+	Settings
+}
+
+// Rule describes a single rule of the game (or setting of the server), for
+// display and editing.
+type Rule struct {
+	Name        string
+	Description string
+	Value       uint64
+}
+
+var ruleList = []struct {
+	name, desc string
+	def        uint64
+	min, max   uint64
+	field      func(r *ruleValues) *uint64
+}{
+	{"Stocks", "Number of stocks listed on the market", 6, 1, uint64(len(stockNames)) - 1, func(r *ruleValues) *uint64 { return &r.Stocks }},
+	{"StartingValue", "Price of a newly listed stock", 100, 1, 1 << 20, func(r *ruleValues) *uint64 { return &r.StartingValue }},
+	{"SplitValue", "Price at which a stock splits 2 for 1", 200, 2, 1 << 21, func(r *ruleValues) *uint64 { return &r.SplitValue }},
+	{"StartingCash", "Cash on hand at the start of each season", 100000, 0, 1 << 40, func(r *ruleValues) *uint64 { return &r.StartingCash }},
+	{"LotSize", "Number of shares in a board lot", 100, 1, 1 << 20, func(r *ruleValues) *uint64 { return &r.LotSize }},
+	{"Rounds", "Number of rounds of market adjustments each day", 15, 1, 1000, func(r *ruleValues) *uint64 { return &r.Rounds }},
+	{"SessionIdleHours", "Hours a login lasts without being used", 7 * 24, 1, 365 * 24, func(r *ruleValues) *uint64 { return &r.SessionIdleHours }},
+	{"SessionDays", "Days a login lasts at most", 30, 1, 3650, func(r *ruleValues) *uint64 { return &r.SessionDays }},
+	{"ArgonTime", "Passes over memory when hashing a password", 1, 1, 16, func(r *ruleValues) *uint64 { return &r.ArgonTime }},
+	{"ArgonMemory", "Memory used to hash a password, in KiB", 64 * 1024, 8 * 1024, 4 * 1024 * 1024, func(r *ruleValues) *uint64 { return &r.ArgonMemory }},
+	{"ArgonThreads", "Threads used to hash a password", 4, 1, 255, func(r *ruleValues) *uint64 { return &r.ArgonThreads }},
+	{"HashMemory", "Memory used to hash passwords at once, in MiB (0 for a share of the server's memory)", 0, 0, 1 << 20, func(r *ruleValues) *uint64 { return &r.HashMemory }},
+}
+
+// ruleMax returns the largest value the rule called name may have.
+func ruleMax(name string) uint64 {
+	for _, v := range ruleList {
+		if v.name == name {
+			return v.max
+		}
+	}
+	panic("Unknown rule: " + name)
+}
+
+func (g *Game) ruleValue(name string, def uint64) uint64 {
+	rv := def
+	g.getGame.QueryRow(name).Scan(&rv)
+	return rv
+}
+
+func (g *Game) ruleValues() ruleValues {
+	var rv ruleValues
+	for _, v := range ruleList {
+		*v.field(&rv) = g.ruleValue(v.name, v.def)
+	}
+	return rv
+}
+
+// Rules returns the current rules of the game.
+func (g *Game) Rules() Rules {
+	return g.ruleValues().Rules
+}
+
+// Settings returns the current settings of the server.
+func (g *Game) Settings() Settings {
+	return g.ruleValues().Settings
+}
+
+// RuleList returns every rule of the game, and every setting of the server,
+// with its current value.
+func (g *Game) RuleList() []Rule {
+	rv := make([]Rule, 0, len(ruleList))
+	for _, v := range ruleList {
+		rv = append(rv, Rule{Name: v.name, Description: v.desc, Value: g.ruleValue(v.name, v.def)})
+	}
+	return rv
+}
+
+// SetRule changes the rule called name to value.
+func (g *Game) SetRule(name, value string) error {
+	return g.SetRules(map[string]string{name: value})
+}
+
+// SetRules changes each rule named in values to its value. The new rules are
+// checked together, so rules which depend on each other can be changed at
+// once, and none are changed unless all of them can be.
+func (g *Game) SetRules(values map[string]string) error {
+	r := g.ruleValues()
+	changed := 0
+	for _, v := range ruleList {
+		value, ok := values[v.name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number", v.name)
+		}
+		if n < v.min || n > v.max {
+			return fmt.Errorf("%s must be between %d and %d", v.name, v.min, v.max)
+		}
+		*v.field(&r) = n
+		changed++
+	}
+	if changed < len(values) {
+		for name := range values {
+			if !isRule(name) {
+				return errors.New("Unknown rule: " + name)
+			}
+		}
+	}
+	if r.SplitValue <= r.StartingValue {
+		if _, ok := values["SplitValue"]; ok {
+			return errors.New("SplitValue must be more than StartingValue")
+		}
+		return errors.New("StartingValue must be less than SplitValue")
+	}
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range ruleList {
+		if _, ok := values[v.name]; !ok {
+			continue
+		}
+		if _, err = tx.Stmt(g.setGame).Exec(v.name, *v.field(&r)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func isRule(name string) bool {
+	for _, v := range ruleList {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package state
+
+import "testing"
+
+func TestSetRules(t *testing.T) {
+	g := testGame(t)
+
+	// Each change on its own would cross the other rule
+	if err := g.SetRules(map[string]string{"StartingValue": "300", "SplitValue": "600"}); err != nil {
+		t.Fatal(err)
+	}
+	if r := g.Rules(); r.StartingValue != 300 || r.SplitValue != 600 {
+		t.Fatalf("Rules are %d/%d, not 300/600", r.StartingValue, r.SplitValue)
+	}
+	if err := g.SetRules(map[string]string{"StartingValue": "50", "SplitValue": "100"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []map[string]string{
+		{"StartingValue": "100"},
+		{"SplitValue": "50"},
+		{"StartingValue": "200", "SplitValue": "150"},
+		{"LotSize": "10", "SplitValue": "x"},
+		{"LotSize": "10", "Rounds": "0"},
+		{"LotSize": "10", "NoSuchRule": "1"},
+	} {
+		if err := g.SetRules(bad); err == nil {
+			t.Errorf("SetRules(%v) succeeded", bad)
+		}
+		if r := g.Rules(); r.StartingValue != 50 || r.SplitValue != 100 || r.LotSize != 100 {
+			t.Fatalf("SetRules(%v) changed the rules to %+v", bad, r)
+		}
+	}
+}
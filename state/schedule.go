@@ -0,0 +1,216 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSchedule plays one turn a day, at midnight.
+const defaultSchedule = "@daily"
+
+// scheduleAliases are shorthand for common schedules.
+var scheduleAliases = map[string]string{
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// schedule is a parsed cron-like schedule: minute, hour, day of month,
+// month, and day of week (0 is Sunday). Each field is a set of bits.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// As in cron, if both the day of month and the day of week are
+	// restricted, a day matching either one matches.
+	domStar, dowStar bool
+}
+
+// parseField parses one field of a schedule, which is a comma separated list
+// of "*", a number, or a range "a-b", each optionally followed by a step
+// "/n".
+func parseField(field string, min, max int) (uint64, error) {
+	var rv uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("Bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("Bad number in %q", field)
+			}
+			hi = lo
+			if len(bounds) > 1 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("Bad number in %q", field)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", field, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			rv |= 1 << uint(i)
+		}
+	}
+	return rv, nil
+}
+
+func parseSchedule(expr string) (*schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := scheduleAliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("A schedule needs 5 fields: minute, hour, day of month, month and day of week")
+	}
+	var s schedule
+	var err error
+	for k, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		*f.bits, err = parseField(fields[k], f.min, f.max)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Sunday is either 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, errors.New("The schedule never runs")
+	}
+	return &s, nil
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that the schedule runs, or the zero
+// time if it doesn't run in the next few years. A time which is skipped when
+// the clocks go forward runs as if they hadn't yet, and a time which is
+// repeated when they go back only runs the first time.
+func (s *schedule) next(t time.Time) time.Time {
+	// Search the wall clock, which has no gaps, and then find when the
+	// clocks in t's location show the time that was found
+	loc := t.Location()
+	w := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	for {
+		w = s.nextWall(w)
+		if w.IsZero() {
+			return w
+		}
+		at := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+		if at.Hour() != w.Hour() || at.Minute() != w.Minute() {
+			_, before := at.Add(-12 * time.Hour).Zone()
+			at = w.Add(-time.Duration(before) * time.Second).In(loc)
+		}
+		if at.After(t) {
+			return at
+		}
+	}
+}
+
+// nextWall returns the first wall clock time after w (in UTC) that the
+// schedule runs, or the zero time if it doesn't run in the next few years.
+func (s *schedule) nextWall(w time.Time) time.Time {
+	w = w.Truncate(time.Minute).Add(time.Minute)
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		switch {
+		case s.month&(1<<uint(w.Month())) == 0:
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(w):
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(w.Hour())) == 0:
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+		case s.minute&(1<<uint(w.Minute())) == 0:
+			w = w.Add(time.Minute)
+		default:
+			return w
+		}
+	}
+	return time.Time{}
+}
+
+// tick returns which of the day's scheduled turns is at (or most recently
+// before) t, counting from 1, and how many scheduled turns there are that
+// day. A time before the day's first turn counts as the first turn.
+func (s *schedule) tick(t time.Time) (int, int) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	tomorrow := day.AddDate(0, 0, 1)
+	k, n := 0, 0
+	for at := s.next(day.Add(-time.Minute)); !at.IsZero() && at.Before(tomorrow); at = s.next(at) {
+		n++
+		if !at.After(t) {
+			k = n
+		}
+	}
+	if n == 0 {
+		// Not a scheduled day, so play the whole day at once
+		return 1, 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return k, n
+}
+
+// roundsAt returns how many of the day's rounds are played by the turn at
+// t. The rounds are spread as evenly as possible over the day's turns.
+func (s *schedule) roundsAt(t time.Time, rounds uint64) uint64 {
+	k, n := s.tick(t)
+	return rounds*uint64(k)/uint64(n) - rounds*uint64(k-1)/uint64(n)
+}
+
+func (g *Game) schedule(t *sql.Tx) *schedule {
+	s, err := parseSchedule(g.gameString(t, "Schedule", defaultSchedule))
+	if err != nil {
+		s, _ = parseSchedule(defaultSchedule)
+	}
+	return s
+}
+
+// Schedule returns the cron-like schedule of the game's turns.
+func (g *Game) Schedule() string {
+	return g.gameString(nil, "Schedule", defaultSchedule)
+}
+
+// SetSchedule changes when turns are played. expr has the five fields of a
+// crontab entry (minute, hour, day of month, month and day of week), or is
+// one of @daily, @midnight or @hourly. For example, "0 9-17 * * 1-5" plays
+// a turn every hour during the working day. The day's rounds are divided
+// between the day's turns.
+func (g *Game) SetSchedule(expr string) error {
+	if _, err := parseSchedule(expr); err != nil {
+		return err
+	}
+	_, err := g.setGame.Exec("Schedule", strings.TrimSpace(expr))
+	return err
+}
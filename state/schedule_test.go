@@ -0,0 +1,196 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func bits(n ...int) uint64 {
+	var rv uint64
+	for _, v := range n {
+		rv |= 1 << uint(v)
+	}
+	return rv
+}
+
+func TestParseField(t *testing.T) {
+	for _, v := range []struct {
+		field    string
+		min, max int
+		want     uint64
+	}{
+		{"*", 0, 5, bits(0, 1, 2, 3, 4, 5)},
+		{"*", 1, 3, bits(1, 2, 3)},
+		{"7", 0, 59, bits(7)},
+		{"1-3", 0, 59, bits(1, 2, 3)},
+		{"1,5,7", 0, 59, bits(1, 5, 7)},
+		{"*/15", 0, 59, bits(0, 15, 30, 45)},
+		{"*/2", 1, 12, bits(1, 3, 5, 7, 9, 11)},
+		{"10/20", 0, 59, bits(10, 30, 50)},
+		{"1-10/3", 0, 59, bits(1, 4, 7, 10)},
+		{"0-4/2,9", 0, 59, bits(0, 2, 4, 9)},
+		{"7", 0, 7, bits(7)},
+	} {
+		got, err := parseField(v.field, v.min, v.max)
+		if err != nil || got != v.want {
+			t.Errorf("parseField(%q, %d, %d) = %b, %v; want %b", v.field, v.min, v.max, got, err, v.want)
+		}
+	}
+	for _, field := range []string{"", "x", "60", "5-1", "1-", "-1", "*/0", "*/x", "1,,2", "0-60"} {
+		if got, err := parseField(field, 0, 59); err == nil {
+			t.Errorf("parseField(%q) = %b; want an error", field, got)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	for _, expr := range []string{"@daily", " @hourly ", "0 9-17 * * 1-5", "*/5 * * * *", "0 0 29 2 *"} {
+		if _, err := parseSchedule(expr); err != nil {
+			t.Errorf("parseSchedule(%q): %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "@weekly", "0 0 * *", "0 0 * * * *", "0 24 * * *", "0 0 31 2 *", "0 0 30 2 *"} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded; want an error", expr)
+		}
+	}
+}
+
+const scheduleLayout = "2006-01-02 15:04 MST"
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, v := range []struct {
+		name string
+		expr string
+		from time.Time
+		want []string
+	}{
+		{"daily", "@daily", time.Date(2026, 1, 30, 12, 0, 0, 0, time.UTC),
+			[]string{"2026-01-31 00:00 UTC", "2026-02-01 00:00 UTC", "2026-02-02 00:00 UTC"}},
+		{"exactly on a turn", "0 * * * *", time.Date(2026, 1, 1, 5, 0, 0, 0, time.UTC),
+			[]string{"2026-01-01 06:00 UTC", "2026-01-01 07:00 UTC"}},
+		{"range of hours", "30 9-11 * * *", time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC),
+			[]string{"2026-01-01 11:30 UTC", "2026-01-02 09:30 UTC", "2026-01-02 10:30 UTC"}},
+		{"step of minutes", "*/20 3 * * *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-01-01 03:00 UTC", "2026-01-01 03:20 UTC", "2026-01-01 03:40 UTC", "2026-01-02 03:00 UTC"}},
+		{"weekdays", "0 9 * * 1-5", time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC), // a Friday
+			[]string{"2026-01-05 09:00 UTC", "2026-01-06 09:00 UTC"}},
+		{"sunday as 7", "0 0 * * 7", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-01-04 00:00 UTC", "2026-01-11 00:00 UTC"}},
+		{"sunday as 0", "0 0 * * 0", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-01-04 00:00 UTC", "2026-01-11 00:00 UTC"}},
+		{"day of month or day of week", "0 0 13 * 5", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-01-02 00:00 UTC", "2026-01-09 00:00 UTC", "2026-01-13 00:00 UTC", "2026-01-16 00:00 UTC"}},
+		{"starred day of month and day of week", "0 0 */2 * 5", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-01-09 00:00 UTC", "2026-01-23 00:00 UTC", "2026-02-13 00:00 UTC"}},
+		{"month", "0 0 1 3,6 *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2026-06-01 00:00 UTC", "2027-03-01 00:00 UTC"}},
+		{"leap day", "0 0 29 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]string{"2028-02-29 00:00 UTC", "2032-02-29 00:00 UTC"}},
+		{"time zone", "0 9 * * *", time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC).In(ny), // 07:00 EST
+			[]string{"2026-01-01 09:00 EST", "2026-01-02 09:00 EST"}},
+		{"skipped time", "30 2 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			[]string{"2026-03-08 03:30 EDT", "2026-03-09 02:30 EDT"}},
+		{"hourly over a skipped hour", "0 * * * *", time.Date(2026, 3, 8, 0, 30, 0, 0, ny),
+			[]string{"2026-03-08 01:00 EST", "2026-03-08 03:00 EDT", "2026-03-08 04:00 EDT"}},
+		{"repeated time", "30 1 * * *", time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			[]string{"2026-11-01 01:30 EDT", "2026-11-02 01:30 EST"}},
+		{"hourly over a repeated hour", "0 * * * *", time.Date(2026, 11, 1, 0, 30, 0, 0, ny),
+			[]string{"2026-11-01 01:00 EDT", "2026-11-01 02:00 EST", "2026-11-01 03:00 EST"}},
+		{"during a repeated hour", "0 * * * *", time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC).In(ny), // 01:30 EST
+			[]string{"2026-11-01 02:00 EST"}},
+	} {
+		s, err := parseSchedule(v.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		at := v.from
+		for _, want := range v.want {
+			at = s.next(at)
+			if got := at.Format(scheduleLayout); got != want {
+				t.Errorf("%s: after %s, got %s; want %s", v.name, v.from.Format(scheduleLayout), got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestTick(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, v := range []struct {
+		expr string
+		at   time.Time
+		k, n int
+	}{
+		{"@daily", time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), 1, 1},
+		{"@hourly", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), 1, 24},
+		{"@hourly", time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC), 13, 24},
+		{"@hourly", time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC), 24, 24},
+		{"0 9-17 * * *", time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC), 1, 9},
+		{"0 9-17 * * *", time.Date(2026, 1, 1, 17, 0, 0, 0, time.UTC), 9, 9},
+		{"0 9-17 * * *", time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC), 9, 9},
+		// Not a scheduled day, so the whole day is played at once
+		{"0 9 * * 1-5", time.Date(2026, 1, 3, 12, 0, 0, 0, time.UTC), 1, 1},
+		{"@hourly", time.Date(2026, 3, 8, 12, 0, 0, 0, ny), 12, 23},
+		{"@hourly", time.Date(2026, 11, 1, 12, 0, 0, 0, ny), 13, 24},
+		{"30 2 * * *", time.Date(2026, 3, 8, 12, 0, 0, 0, ny), 1, 1},
+	} {
+		s, err := parseSchedule(v.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k, n := s.tick(v.at); k != v.k || n != v.n {
+			t.Errorf("%q at %s: tick is %d of %d; want %d of %d", v.expr, v.at.Format(scheduleLayout), k, n, v.k, v.n)
+		}
+	}
+}
+
+func TestRoundsAt(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	days := []time.Time{
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), // a Saturday
+		time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+		time.Date(2026, 11, 1, 0, 0, 0, 0, ny),
+	}
+	for _, expr := range []string{"@daily", "@hourly", "0 9-17 * * *", "0 9 * * 1-5", "*/7 * * * *", "30 2 * * *", "0 0,12 * * *"} {
+		s, err := parseSchedule(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rounds := range []uint64{1, 7, 15, 1000} {
+			for _, day := range days {
+				tomorrow := day.AddDate(0, 0, 1)
+				var turns []time.Time
+				for at := s.next(day.Add(-time.Minute)); !at.IsZero() && at.Before(tomorrow); at = s.next(at) {
+					turns = append(turns, at)
+				}
+				if len(turns) == 0 {
+					// The day's only turn is the next scheduled one
+					turns = append(turns, day.Add(12*time.Hour))
+				}
+				var sum uint64
+				for _, at := range turns {
+					n := s.roundsAt(at, rounds)
+					if n > rounds/uint64(len(turns))+1 {
+						t.Errorf("%q plays %d of %d rounds at %s", expr, n, rounds, at.Format(scheduleLayout))
+					}
+					sum += n
+				}
+				if sum != rounds {
+					t.Errorf("%q plays %d rounds on %s; want %d", expr, sum, day.Format("2006-01-02 MST"), rounds)
+				}
+			}
+		}
+	}
+}
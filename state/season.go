@@ -0,0 +1,378 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSeasonLength = "monthly"
+
+// seasonAliases are shorthand for common season lengths.
+var seasonAliases = map[string]string{
+	"weekly":   "1 week",
+	"biweekly": "2 weeks",
+	"monthly":  "1 month",
+}
+
+// seasonLength is a parsed season length: n weeks, months or turns, or the
+// explicit dates in the season calendar (unit "dates").
+type seasonLength struct {
+	n    int
+	unit string
+}
+
+func parseSeasonLength(def string) (seasonLength, error) {
+	def = strings.ToLower(strings.TrimSpace(def))
+	if alias, ok := seasonAliases[def]; ok {
+		def = alias
+	}
+	if def == "dates" {
+		return seasonLength{unit: "dates"}, nil
+	}
+	f := strings.Fields(def)
+	if len(f) == 2 {
+		n, err := strconv.Atoi(f[0])
+		unit := strings.TrimSuffix(f[1], "s")
+		if err == nil && n > 0 && n <= 1000 && (unit == "week" || unit == "month" || unit == "turn") {
+			return seasonLength{n: n, unit: unit}, nil
+		}
+	}
+	return seasonLength{}, errors.New("A season length is weekly, biweekly, monthly, N weeks, N months, N turns, or dates")
+}
+
+// monday is the Monday which weekly seasons count from.
+var monday = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+// SeasonDates are the start and end of a season. Number is the season's
+// number, if it is known, and ID identifies an entry in the season
+// calendar.
+type SeasonDates struct {
+	ID     int64
+	Number int
+	Start  time.Time
+	End    time.Time
+}
+
+// LastDay returns the last day of the season, or the zero time if the
+// season has no end.
+func (sd SeasonDates) LastDay() time.Time {
+	if sd.End.IsZero() {
+		return sd.End
+	}
+	return sd.End.Add(-time.Second)
+}
+
+func (g *Game) seasonLength(t *sql.Tx) seasonLength {
+	sl, err := parseSeasonLength(g.gameString(t, "SeasonLength", defaultSeasonLength))
+	if err != nil {
+		sl, _ = parseSeasonLength(defaultSeasonLength)
+	}
+	return sl
+}
+
+// SeasonLength returns the definition of the length of a season.
+func (g *Game) SeasonLength() string {
+	return g.gameString(nil, "SeasonLength", defaultSeasonLength)
+}
+
+// DescribeSeasonLength describes the length of a season, for people.
+func (g *Game) DescribeSeasonLength() string {
+	sl := g.seasonLength(nil)
+	switch {
+	case sl.unit == "dates":
+		return "on the dates in the season calendar"
+	case sl.n == 1:
+		return "every " + sl.unit
+	}
+	return fmt.Sprintf("every %d %ss", sl.n, sl.unit)
+}
+
+// SetSeasonLength changes the length of a season to def, which is weekly,
+// biweekly, monthly, "N weeks", "N months", "N turns", or "dates" to use
+// the season calendar. The current season ends when a season of the new
+// length that started with it would end.
+func (g *Game) SetSeasonLength(def string) error {
+	if _, err := parseSeasonLength(def); err != nil {
+		return err
+	}
+	_, err := g.setGame.Exec("SeasonLength", strings.ToLower(strings.TrimSpace(def)))
+	if err != nil {
+		return err
+	}
+	start := g.seasonStart(nil)
+	_, err = g.setGame.Exec("SeasonEnd", formatEnd(g.nextSeasonEnd(nil, start)))
+	return err
+}
+
+func formatEnd(end time.Time) string {
+	if end.IsZero() {
+		return ""
+	}
+	return end.UTC().Format(sqliteDate)
+}
+
+// nextSeasonEnd returns when a season that started at start ends, or the
+// zero time if it never ends.
+func (g *Game) nextSeasonEnd(t *sql.Tx, start time.Time) time.Time {
+	loc := g.location(t)
+	start = start.In(loc)
+	sl := g.seasonLength(t)
+	switch sl.unit {
+	case "week":
+		day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		weeks := int(day.Sub(monday).Hours() / 24 / 7)
+		weeks = (weeks/sl.n + 1) * sl.n
+		end := monday.AddDate(0, 0, weeks*7)
+		return time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+	case "month":
+		months := start.Year()*12 + int(start.Month()) - 1
+		months = (months/sl.n + 1) * sl.n
+		return time.Date(months/12, time.Month(months%12+1), 1, 0, 0, 0, 0, loc)
+	case "turn":
+		at := start
+		s := g.schedule(t)
+		for i := 0; i < sl.n && !at.IsZero(); i++ {
+			at = s.next(at)
+		}
+		return at
+	case "dates":
+		for _, v := range g.seasonDates(t) {
+			if v.End.After(start) {
+				return v.End
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (g *Game) seasonTime(t *sql.Tx, key string) (time.Time, bool) {
+	s := g.getGame
+	if t != nil {
+		s = t.Stmt(s)
+	}
+	var value string
+	if s.QueryRow(key).Scan(&value) != nil {
+		return time.Time{}, false
+	}
+	rv, err := time.Parse(sqliteDate, value)
+	return rv.In(g.location(t)), err == nil || len(value) < 1
+}
+
+// seasonStart returns when the current season started.
+func (g *Game) seasonStart(t *sql.Tx) time.Time {
+	start, ok := g.seasonTime(t, "SeasonStart")
+	if !ok || start.IsZero() {
+		// Games from before seasons were recorded started with a month
+		start, _ = g.getPrevRun(t)
+		start = start.In(g.location(t))
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location())
+	}
+	return start
+}
+
+// seasonEnd returns when the current season ends, or the zero time if it
+// doesn't.
+func (g *Game) seasonEnd(t *sql.Tx) time.Time {
+	end, ok := g.seasonTime(t, "SeasonEnd")
+	if !ok {
+		return g.nextSeasonEnd(t, g.seasonStart(t))
+	}
+	return end
+}
+
+// CurrentSeason returns the dates of the current season. End is zero if the
+// season has no end yet.
+func (g *Game) CurrentSeason() SeasonDates {
+	return SeasonDates{Number: g.Season(), Start: g.seasonStart(nil), End: g.seasonEnd(nil)}
+}
+
+// nextSeason returns the dates of a season starting at from. A season in
+// the season calendar may start later than from.
+func (g *Game) nextSeason(t *sql.Tx, from time.Time) SeasonDates {
+	rv := SeasonDates{Start: from, End: g.nextSeasonEnd(t, from)}
+	if g.seasonLength(t).unit == "dates" {
+		for _, v := range g.seasonDates(t) {
+			if v.End.Equal(rv.End) && v.Start.After(from) {
+				rv.Start = v.Start
+			}
+		}
+	}
+	return rv
+}
+
+// SeasonCalendar returns the current season, followed by up to n upcoming
+// seasons.
+func (g *Game) SeasonCalendar(n int) []SeasonDates {
+	cur := g.CurrentSeason()
+	rv := []SeasonDates{cur}
+	for len(rv) <= n && !cur.End.IsZero() {
+		next := g.nextSeason(nil, cur.End)
+		if next.End.IsZero() {
+			break
+		}
+		next.Number = cur.Number + 1
+		rv = append(rv, next)
+		cur = next
+	}
+	return rv
+}
+
+// SeasonDates returns the season calendar, which is used when seasons are
+// defined by dates, in order.
+func (g *Game) SeasonDates() []SeasonDates {
+	return g.seasonDates(nil)
+}
+
+func (g *Game) seasonDates(t *sql.Tx) []SeasonDates {
+	var rv []SeasonDates
+	s := g.getSeasonDates
+	if t != nil {
+		s = t.Stmt(s)
+	}
+	r, err := s.Query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	loc := g.location(t)
+	for r.Next() {
+		var sd SeasonDates
+		var start, end string
+		r.Scan(&sd.ID, &start, &end)
+		sd.Start, _ = time.Parse(sqliteDate, start)
+		sd.End, _ = time.Parse(sqliteDate, end)
+		sd.Start, sd.End = sd.Start.In(loc), sd.End.In(loc)
+		rv = append(rv, sd)
+	}
+	return rv
+}
+
+// AddSeasonDates adds a season from start to end to the season calendar.
+// Seasons in the calendar may not overlap.
+func (g *Game) AddSeasonDates(start, end time.Time) error {
+	if !end.After(start) {
+		return errors.New("A season must end after it starts")
+	}
+	for _, v := range g.SeasonDates() {
+		if start.Before(v.End) && v.Start.Before(end) {
+			return fmt.Errorf("That season overlaps the season from %s to %s",
+				v.Start.Format("2006-01-02"), v.End.Format("2006-01-02"))
+		}
+	}
+	_, err := g.addSeasonDate.Exec(start.UTC().Format(sqliteDate), end.UTC().Format(sqliteDate))
+	if err == nil {
+		err = g.refreshSeasonEnd()
+	}
+	return err
+}
+
+// DeleteSeasonDates removes a season from the season calendar.
+func (g *Game) DeleteSeasonDates(id int64) error {
+	r := g.deleteSeasonDate.QueryRow(id)
+	err := r.Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("No such season")
+	}
+	if err == nil {
+		err = g.refreshSeasonEnd()
+	}
+	return err
+}
+
+// refreshSeasonEnd recalculates the end of the current season after the
+// season calendar changes.
+func (g *Game) refreshSeasonEnd() error {
+	if g.seasonLength(nil).unit != "dates" {
+		return nil
+	}
+	_, err := g.setGame.Exec("SeasonEnd", formatEnd(g.nextSeasonEnd(nil, g.seasonStart(nil))))
+	return err
+}
+
+// ExtendSeason moves the end of the current season later by d.
+func (g *Game) ExtendSeason(d time.Duration) error {
+	end := g.seasonEnd(nil)
+	if end.IsZero() {
+		return errors.New("The current season doesn't have an end to extend")
+	}
+	if d <= 0 {
+		return errors.New("A season can only be extended by a positive amount")
+	}
+	_, err := g.setGame.Exec("SeasonEnd", formatEnd(end.Add(d)))
+	return err
+}
+
+// EndSeason ends the current season immediately, and starts the next one.
+func (g *Game) EndSeason() {
+	now := time.Now().In(g.Location())
+	rules := g.Rules()
+	seed := g.turnSeed(now.UTC().Format(sqliteDate))
+	for {
+		tx, _ := g.db.Begin()
+		tx.Exec("DELETE FROM News")
+		g.endSeason(tx, now, now, rules, rand.New(rand.NewSource(seed)))
+		err := tx.Commit()
+		if err == nil {
+			g.Notify("turn")
+			return
+		}
+		log.Println(err)
+		tx.Rollback()
+	}
+}
+
+// seasonName describes the current season, which is ending at end.
+func (g *Game) seasonName(t *sql.Tx, end time.Time) string {
+	start := g.seasonStart(t)
+	last := end.Add(-time.Second)
+	sl := g.seasonLength(t)
+	if sl.unit == "month" && sl.n == 1 {
+		return fmt.Sprintf("%s %d season", last.Month().String(), last.Year())
+	}
+	if start.Year() != last.Year() {
+		return fmt.Sprintf("season from %s to %s", start.Format("January 2, 2006"), last.Format("January 2, 2006"))
+	}
+	return fmt.Sprintf("season from %s to %s", start.Format("January 2"), last.Format("January 2, 2006"))
+}
+
+// endSeason announces the winner of the current season, which ended at end,
+// and starts a new season at now. The new season's stocks are named using
+// rng.
+func (g *Game) endSeason(tx *sql.Tx, end, now time.Time, rules Rules, rng *rand.Rand) {
+	leader := g.leaders(tx)
+	sort.Sort(LeaderSort(leader))
+	g.archiveSeason(tx, end, leader)
+	if len(leader) > 0 {
+		announce := fmt.Sprintf("The winner of the %s was %s, with a net worth of $%d",
+			g.seasonName(tx, end), leader[0].Name, leader[0].Worth)
+		tx.Stmt(g.addNews).Exec(announce)
+		tx.Stmt(g.addHistory).Exec(announce)
+	}
+	if len(leader) > 1 {
+		rup := []string{}
+		for i := 1; i < len(leader); i++ {
+			rup = append(rup, fmt.Sprintf("%s had $%d", leader[i].Name, leader[i].Worth))
+		}
+		tx.Stmt(g.addNews).Exec(fmt.Sprintf("(%s)", strings.Join(rup, ", ")))
+	}
+	g.reset(tx, rules, rng)
+	next := g.nextSeason(tx, now)
+	tx.Stmt(g.setGame).Exec("SeasonStart", next.Start.UTC().Format(sqliteDate))
+	tx.Stmt(g.setGame).Exec("SeasonEnd", formatEnd(next.End))
+}
+
+// StartSeason records that the current season started at now, and works
+// out when it ends. A new game's first season starts when it is created,
+// but a simulated game starts its first season at the simulated time.
+func (g *Game) StartSeason(now time.Time) {
+	next := g.nextSeason(nil, now)
+	g.setGame.Exec("SeasonStart", next.Start.UTC().Format(sqliteDate))
+	g.setGame.Exec("SeasonEnd", formatEnd(next.End))
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestSeasonRollover plays turns across the ends of seasons in a shared-cache
+// in-memory game, as simulate does, where a read made outside the turn's
+// transaction would wait for it forever.
+func TestSeasonRollover(t *testing.T) {
+	g := Create(fmt.Sprintf("file:rollover%d?mode=memory&cache=shared", os.Getpid()))
+	if g == nil {
+		t.Fatal("Unable to create game")
+	}
+	defer g.Close()
+	testPlayer(t, g, "alice")
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	g.StartSeason(start)
+	for _, v := range []struct {
+		length    string
+		turn      time.Time
+		number    int
+		nextStart time.Time
+	}{
+		{"monthly", time.Date(2000, time.January, 2, 0, 0, 0, 0, time.UTC), 1, start},
+		{"monthly", time.Date(2000, time.February, 1, 0, 0, 0, 0, time.UTC), 2, time.Date(2000, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"weekly", time.Date(2000, time.February, 7, 0, 0, 0, 0, time.UTC), 3, time.Date(2000, time.February, 7, 0, 0, 0, 0, time.UTC)},
+		{"2 turns", time.Date(2000, time.February, 8, 0, 0, 0, 0, time.UTC), 3, time.Date(2000, time.February, 7, 0, 0, 0, 0, time.UTC)},
+		{"2 turns", time.Date(2000, time.February, 9, 0, 0, 0, 0, time.UTC), 4, time.Date(2000, time.February, 9, 0, 0, 0, 0, time.UTC)},
+	} {
+		if err := g.SetSeasonLength(v.length); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan bool)
+		go func() {
+			g.Advance(v.turn)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("The turn on %s didn't finish", v.turn)
+		}
+		cur := g.CurrentSeason()
+		if cur.Number != v.number || !cur.Start.Equal(v.nextStart) {
+			t.Errorf("After the turn on %s (%s), season %d started %s; want season %d from %s",
+				v.turn, v.length, cur.Number, cur.Start, v.number, v.nextStart)
+		}
+	}
+	if n := len(g.Seasons()); n != 3 {
+		t.Errorf("%d seasons were archived, want 3", n)
+	}
+}
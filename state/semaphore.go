@@ -0,0 +1,155 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when a password can't be hashed because the server is
+// already hashing as many passwords as it can afford to.
+var ErrBusy = errors.New("The server is busy, please try again in a moment")
+
+// hashQueue is how many password hashes may wait for the ones in progress,
+// and hashWait is how long each may wait, before ErrBusy is returned.
+const (
+	hashQueue = 16
+	hashWait  = 5 * time.Second
+)
+
+// Unless the HashMemory setting says otherwise, password hashing may use a
+// quarter of the memory that is available when the server starts, or if
+// that can't be found, defaultHashMemory (in KiB).
+const (
+	hashShare         = 4
+	defaultHashMemory = 256 * 1024
+)
+
+var (
+	autoHashOnce   sync.Once
+	autoHashMemory int64
+)
+
+// hashMemory returns the most memory, in KiB, that may be used to hash
+// passwords at once.
+func (g *Game) hashMemory() int64 {
+	if mib := g.Settings().HashMemory; mib > 0 {
+		return int64(mib) * 1024
+	}
+	autoHashOnce.Do(func() {
+		autoHashMemory = defaultHashMemory
+		if avail := availableMemory(); avail > 0 {
+			autoHashMemory = avail / 1024 / hashShare
+		}
+	})
+	return autoHashMemory
+}
+
+// availableMemory returns the memory (in bytes) available to the server,
+// which is the least of the memory the kernel reports as available and any
+// room left under the server's cgroup limit, or 0 if neither can be found.
+func availableMemory() int64 {
+	var rv int64
+	least := func(n int64) {
+		if n > 0 && (rv == 0 || n < rv) {
+			rv = n
+		}
+	}
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			// MemAvailable:    1234567 kB
+			fields := strings.Fields(sc.Text())
+			if len(fields) == 3 && fields[0] == "MemAvailable:" && fields[2] == "kB" {
+				kib, _ := strconv.ParseInt(fields[1], 10, 64)
+				least(kib * 1024)
+			}
+		}
+		f.Close()
+	}
+	// cgroup v2 (whose limit may be "max"), then v1 (whose lack of a limit
+	// is merely very large)
+	for _, v := range [][2]string{
+		{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory.current"},
+		{"/sys/fs/cgroup/memory/memory.limit_in_bytes", "/sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	} {
+		b, err := os.ReadFile(v[0])
+		if err != nil {
+			continue
+		}
+		if limit, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+			b, _ = os.ReadFile(v[1])
+			used, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+			least(limit - used)
+		}
+		break
+	}
+	return rv
+}
+
+// semaphore limits the password hashes in progress, both by the memory they
+// use (in KiB), and by their number, which is at most the number of CPUs.
+// Waiters are woken whenever a hash finishes, and check again.
+type semaphore struct {
+	mu      sync.Mutex
+	used    int64
+	running int
+	waiters []chan struct{}
+}
+
+var hashing semaphore
+
+// acquire reserves weight out of capacity, waiting if necessary. A weight
+// larger than capacity reserves all of it. acquire returns the weight that
+// was reserved, which must be passed to release.
+func (s *semaphore) acquire(weight, capacity int64) (int64, error) {
+	if weight > capacity {
+		weight = capacity
+	}
+	deadline := time.NewTimer(hashWait)
+	defer deadline.Stop()
+	s.mu.Lock()
+	for s.running >= runtime.NumCPU() || s.used+weight > capacity {
+		if len(s.waiters) >= hashQueue {
+			s.mu.Unlock()
+			return 0, ErrBusy
+		}
+		wake := make(chan struct{})
+		s.waiters = append(s.waiters, wake)
+		s.mu.Unlock()
+		select {
+		case <-wake:
+		case <-deadline.C:
+			s.mu.Lock()
+			for k, v := range s.waiters {
+				if v == wake {
+					s.waiters = append(s.waiters[:k], s.waiters[k+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+			return 0, ErrBusy
+		}
+		s.mu.Lock()
+	}
+	s.used += weight
+	s.running++
+	s.mu.Unlock()
+	return weight, nil
+}
+
+func (s *semaphore) release(weight int64) {
+	s.mu.Lock()
+	s.used -= weight
+	s.running--
+	for _, v := range s.waiters {
+		close(v)
+	}
+	s.waiters = nil
+	s.mu.Unlock()
+}
@@ -0,0 +1,26 @@
+package state
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestHashMemory(t *testing.T) {
+	g := testGame(t)
+	if s := g.Settings(); s.HashMemory != 0 {
+		t.Fatalf("HashMemory defaults to %d, not 0", s.HashMemory)
+	}
+	if avail := availableMemory(); avail <= 0 && runtime.GOOS == "linux" {
+		t.Error("Unable to find the memory available")
+	}
+	if auto := g.hashMemory(); auto <= 0 {
+		t.Errorf("Hashing may use %d KiB", auto)
+	}
+
+	if err := g.SetRule("HashMemory", "8"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.hashMemory(); got != 8*1024 {
+		t.Errorf("Hashing may use %d KiB, not the 8 MiB set", got)
+	}
+}
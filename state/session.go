@@ -0,0 +1,109 @@
+package state
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Session is a login on one browser (or other client). Sessions expire
+// after the SessionIdleHours setting if they aren't used, and after the
+// SessionDays setting regardless.
+type Session struct {
+	ID        int64
+	Created   time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+	// Current is true for the session used to make the request
+	Current bool
+}
+
+func (g *Game) sessionHash(cookie []byte) []byte {
+	return KMAC128("session", g.getKey(), cookie, 256)
+}
+
+// sessionLimits returns the SQLite date modifiers for the idle and absolute
+// expiry of sessions.
+func (g *Game) sessionLimits() (string, string) {
+	settings := g.Settings()
+	return fmt.Sprintf("-%d hours", settings.SessionIdleHours), fmt.Sprintf("-%d days", settings.SessionDays)
+}
+
+// SessionLength is the longest a new session can last.
+func (g *Game) SessionLength() time.Duration {
+	return time.Duration(g.Settings().SessionDays) * 24 * time.Hour
+}
+
+// NewSession starts a new session for the player, from a client with the
+// given user agent and IP address, and returns the session cookie. Any
+// other sessions the player has are left alone.
+func (p *PlayerInfo) NewSession(userAgent, ip string) []byte {
+	cookie := make([]byte, 256/8)
+	rand.Read(cookie)
+	idle, max := p.g.sessionLimits()
+	_, err := p.g.addSession.Exec(p.playerID, p.g.sessionHash(cookie), userAgent, ip, idle, max)
+	if err != nil {
+		log.Println(err)
+	}
+	return cookie
+}
+
+// PlayerByCookie finds the player whose session has the given cookie, as
+// long as the session hasn't expired, and records that the session was used.
+func (g *Game) PlayerByCookie(cookie []byte) (string, *PlayerInfo) {
+	if cookie == nil || len(cookie) < 10 {
+		return "", nil
+	}
+	rv := PlayerInfo{g: g}
+	var name string
+	idle, max := g.sessionLimits()
+	r := g.findPlayerBySession.QueryRow(g.sessionHash(cookie), idle, max)
+	err := r.Scan(&name, &rv.playerID, &rv.session)
+	if err != nil {
+		return "", nil
+	}
+	return name, &rv
+}
+
+// Sessions lists the player's sessions which haven't expired, most
+// recently used first.
+func (p *PlayerInfo) Sessions() []Session {
+	rv := make([]Session, 0)
+	idle, max := p.g.sessionLimits()
+	r, err := p.g.getSessions.Query(p.playerID, idle, max)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var s Session
+		var created, seen string
+		r.Scan(&s.ID, &created, &seen, &s.UserAgent, &s.IP)
+		s.Created, _ = time.Parse(sqliteDate, created)
+		s.LastSeen, _ = time.Parse(sqliteDate, seen)
+		s.Current = s.ID == p.session
+		rv = append(rv, s)
+	}
+	return rv
+}
+
+// EndSession revokes one of the player's sessions.
+func (p *PlayerInfo) EndSession(id int64) error {
+	r := p.g.endSession.QueryRow(id, p.playerID)
+	err := r.Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("No such session")
+	}
+	return err
+}
+
+// Logout revokes the session used to find the player, if any.
+func (p *PlayerInfo) Logout() {
+	if p.session != 0 {
+		p.EndSession(p.session)
+	}
+}
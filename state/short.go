@@ -0,0 +1,178 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Short sellers must keep enough cash on hand to buy back their short
+// positions, plus a margin. The margins are percentages of the current value
+// of the short positions. shortMargin must be met whenever a player opens a
+// short position (or spends cash), and a player who falls below
+// shortMaintenance during the daily market adjustments has their short
+// positions bought back at the market price.
+const shortMargin = 150
+const shortMaintenance = 125
+
+// checkMargin returns an error if the player doesn't have margin percent of
+// the value of their short positions in cash.
+func (g *Game) checkMargin(tx *sql.Tx, player int, margin uint64) error {
+	var cash, value uint64
+	tx.Stmt(g.getHolding).QueryRow(player, "Cash").Scan(&cash)
+	tx.Stmt(g.shortValue).QueryRow(player).Scan(&value)
+	if cash*100 < value*margin {
+		return fmt.Errorf("You need at least $%d cash on hand to cover your short positions", value*margin/100)
+	}
+	return nil
+}
+
+func (p *PlayerInfo) Short(stock string, lots uint64) error {
+	if lots < 1 {
+		return errors.New("A short sale must be for at least one board lot")
+	}
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	shares := lots * p.g.Rules().LotSize
+	for {
+		tx, _ = p.g.db.Begin()
+		idx := p.g.findStock(tx, stock)
+		if idx < 0 {
+			return fmt.Errorf("%s is not on the market", stock)
+		}
+
+		r := tx.Stmt(p.g.short).QueryRow(p.playerID, idx, shares)
+		var cash int64
+		err := r.Scan(&cash)
+		if err != nil {
+			if isBusy(err) {
+				tx.Rollback()
+				continue
+			}
+			return err
+		}
+		if err = p.g.checkMargin(tx, p.playerID, shortMargin); err != nil {
+			return err
+		}
+		tx.Stmt(p.g.addLedger).Exec(p.playerID, idx, -int64(shares), nil, "short")
+		err = tx.Commit()
+		if isBusy(err) {
+			tx.Rollback()
+			continue
+		}
+		return err
+	}
+}
+
+func (p *PlayerInfo) Cover(stock string, lots uint64) error {
+	if lots < 1 {
+		return errors.New("A cover must be for at least one board lot")
+	}
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	shares := lots * p.g.Rules().LotSize
+	for {
+		tx, _ = p.g.db.Begin()
+		idx := p.g.findStock(tx, stock)
+		if idx < 0 {
+			return fmt.Errorf("%s is not on the market", stock)
+		}
+
+		var short, cash, value uint64
+		err := tx.Stmt(p.g.getShort).QueryRow(p.playerID, idx).Scan(&short)
+		if err == nil {
+			err = tx.Stmt(p.g.getHolding).QueryRow(p.playerID, "Cash").Scan(&cash)
+		}
+		if err == nil {
+			err = tx.Stmt(p.g.getStockValue).QueryRow(idx).Scan(&value)
+		}
+		if isBusy(err) {
+			tx.Rollback()
+			continue
+		}
+		if short < shares {
+			return fmt.Errorf("You haven't sold %d shares of %s short", shares, stock)
+		}
+		if cash < shares*value {
+			return fmt.Errorf("You don't have enough cash to buy back %d shares of %s", shares, stock)
+		}
+		_, err = tx.Stmt(p.g.cover).Exec(p.playerID, idx, shares, value)
+		if err == nil {
+			tx.Stmt(p.g.addLedger).Exec(p.playerID, idx, shares, value, "cover")
+			err = tx.Commit()
+		}
+		if isBusy(err) {
+			tx.Rollback()
+			continue
+		}
+		return err
+	}
+}
+
+// forceCover buys back the short positions of every player who no longer
+// meets the maintenance margin at the given prices. If a player doesn't have
+// enough cash to buy back every share, they are left with no cash. It returns
+// news items describing the players who were covered.
+func (g *Game) forceCover(tx *sql.Tx, stocks []Stock) []string {
+	type position struct {
+		player, stock int
+		shares        uint64
+	}
+	var positions []position
+	r, err := tx.Stmt(g.listShorts).Query()
+	if err != nil {
+		return nil
+	}
+	for r.Next() {
+		var p position
+		r.Scan(&p.player, &p.stock, &p.shares)
+		if p.stock >= 1 && p.stock <= len(stocks) {
+			positions = append(positions, p)
+		}
+	}
+	r.Close()
+
+	var players []int
+	value := make(map[int]uint64)
+	for _, p := range positions {
+		if _, ok := value[p.player]; !ok {
+			players = append(players, p.player)
+		}
+		value[p.player] += p.shares * stocks[p.stock-1].Value
+	}
+	var news []string
+	for _, player := range players {
+		v := value[player]
+		var cash uint64
+		tx.Stmt(g.getHolding).QueryRow(player, "Cash").Scan(&cash)
+		if cash*100 >= v*shortMaintenance {
+			continue
+		}
+		for _, p := range positions {
+			if p.player != player {
+				continue
+			}
+			price := stocks[p.stock-1].Value
+			tx.Stmt(g.cover).Exec(player, p.stock, p.shares, price)
+			tx.Stmt(g.addLedger).Exec(player, p.stock, p.shares, price, "forced cover")
+			if cost := p.shares * price; cost > cash {
+				tx.Stmt(g.addLedgerCash).Exec(player, p.stock, cost-cash, "margin write-off")
+				cash = 0
+			} else {
+				cash -= cost
+			}
+		}
+		var name string
+		tx.Stmt(g.getPlayerName).QueryRow(player).Scan(&name)
+		news = append(news, fmt.Sprintf("%s's short positions were bought back after a margin call", name))
+	}
+	return news
+}
@@ -0,0 +1,21 @@
+package state
+
+import "testing"
+
+func TestShortCoverZeroLots(t *testing.T) {
+	g := testGame(t)
+	stock := g.ListStocks()[0].Name
+	alice := testPlayer(t, g, "alice")
+	if err := alice.Short(stock, 0); err == nil {
+		t.Error("Sold no shares short")
+	}
+	if err := alice.Short(stock, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Cover(stock, 0); err == nil {
+		t.Error("Covered no shares")
+	}
+	if l := alice.Ledger(); len(l) != 1 {
+		t.Errorf("alice's ledger is %+v", l)
+	}
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// initialRating is the rating of a player before their first season.
+const initialRating = 1500
+
+// ratingK is the most a player's rating can change in one season.
+const ratingK = 32
+
+// PlayerStats summarizes how a player has done over every season that has
+// ended. Rating is an Elo-style rating: each season counts as a game
+// against every other player in it, won by whoever finished higher.
+type PlayerStats struct {
+	Name          string
+	Seasons       int
+	Wins          int
+	Podiums       int
+	BestWorth     uint64
+	AverageWorth  uint64
+	AverageRank   float64
+	LongestStreak int
+	CurrentStreak int
+	Rating        int
+}
+
+type seasonFinish struct {
+	season int
+	player int
+	name   string
+	rank   int
+	worth  uint64
+}
+
+// allStats works out the statistics of every player who has finished a
+// season, keyed by PlayerID, so that a new player who takes a deleted
+// player's name starts afresh. Each is named for the player's current name,
+// or if they have been deleted, the name they last played under.
+func (g *Game) allStats() map[int]*PlayerStats {
+	r, err := g.getAllResults.Query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var finishes []seasonFinish
+	for r.Next() {
+		var f seasonFinish
+		r.Scan(&f.season, &f.player, &f.name, &f.rank, &f.worth)
+		finishes = append(finishes, f)
+	}
+	r.Close()
+
+	stats := make(map[int]*PlayerStats)
+	rating := make(map[int]float64)
+	totalWorth := make(map[int]uint64)
+	totalRank := make(map[int]int)
+	// finishes are in order of season, so each season is a run of them
+	for start := 0; start < len(finishes); {
+		end := start
+		for end < len(finishes) && finishes[end].season == finishes[start].season {
+			end++
+		}
+		season := finishes[start:end]
+
+		delta := make(map[int]float64)
+		for i, a := range season {
+			if _, ok := rating[a.player]; !ok {
+				rating[a.player] = initialRating
+			}
+			for j, b := range season {
+				if i == j {
+					continue
+				}
+				if _, ok := rating[b.player]; !ok {
+					rating[b.player] = initialRating
+				}
+				score := 0.5
+				if a.rank < b.rank {
+					score = 1
+				} else if a.rank > b.rank {
+					score = 0
+				}
+				expect := 1 / (1 + math.Pow(10, (rating[b.player]-rating[a.player])/400))
+				delta[a.player] += ratingK * (score - expect) / float64(len(season)-1)
+			}
+		}
+
+		won := make(map[int]bool)
+		for _, f := range season {
+			rating[f.player] += delta[f.player]
+			s, ok := stats[f.player]
+			if !ok {
+				s = &PlayerStats{}
+				stats[f.player] = s
+			}
+			s.Name = f.name
+			s.Seasons++
+			totalWorth[f.player] += f.worth
+			totalRank[f.player] += f.rank
+			if f.worth > s.BestWorth {
+				s.BestWorth = f.worth
+			}
+			if f.rank <= 3 {
+				s.Podiums++
+			}
+			if f.rank == 1 {
+				s.Wins++
+				won[f.player] = true
+			}
+		}
+		// A streak ends with any season that the player didn't win,
+		// including one they didn't play
+		for id, s := range stats {
+			if won[id] {
+				s.CurrentStreak++
+				if s.CurrentStreak > s.LongestStreak {
+					s.LongestStreak = s.CurrentStreak
+				}
+			} else {
+				s.CurrentStreak = 0
+			}
+		}
+		start = end
+	}
+
+	for id, s := range stats {
+		s.AverageWorth = totalWorth[id] / uint64(s.Seasons)
+		s.AverageRank = float64(totalRank[id]) / float64(s.Seasons)
+		s.Rating = int(math.Round(rating[id]))
+	}
+	return stats
+}
+
+// PlayerStats returns the statistics of the named player, or nil if they
+// haven't finished a season.
+func (g *Game) PlayerStats(name string) *PlayerStats {
+	id := -1
+	if g.findPlayer.QueryRow(name).Scan(&id) != nil {
+		return nil
+	}
+	return g.allStats()[id]
+}
+
+// HallOfFame returns the statistics of every player who has finished a
+// season, with the most wins first, and then the highest rating.
+func (g *Game) HallOfFame() []PlayerStats {
+	rv := make([]PlayerStats, 0)
+	for _, s := range g.allStats() {
+		rv = append(rv, *s)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Wins != rv[j].Wins {
+			return rv[i].Wins > rv[j].Wins
+		}
+		if rv[i].Rating != rv[j].Rating {
+			return rv[i].Rating > rv[j].Rating
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	return rv
+}
@@ -0,0 +1,48 @@
+package state
+
+import "testing"
+
+func TestStatsByPlayer(t *testing.T) {
+	g := testGame(t)
+	finish := func(season int, p *PlayerInfo, name string, rank int) {
+		t.Helper()
+		_, err := g.db.Exec("INSERT INTO SeasonResult (SeasonID, PlayerID, Name, Rank, Worth, Cash) VALUES (?1, ?2, ?3, ?4, ?5, 0)",
+			season, p.playerID, name, rank, 1000/rank)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := testPlayer(t, g, "alice")
+	bob := testPlayer(t, g, "bob")
+	finish(1, bob, "bob", 1)
+	finish(1, alice, "alice", 2)
+	if !g.DeletePlayer("bob") {
+		t.Fatal("Unable to delete bob")
+	}
+
+	// The new bob mustn't inherit the old bob's win
+	if s := g.PlayerStats("bob"); s != nil {
+		t.Errorf("The new bob has stats %+v", s)
+	}
+	newBob := testPlayer(t, g, "bob")
+	finish(2, alice, "alice", 1)
+	finish(2, newBob, "bob", 2)
+	s := g.PlayerStats("bob")
+	if s == nil || s.Seasons != 1 || s.Wins != 0 || s.Rating >= initialRating {
+		t.Errorf("The new bob has stats %+v", s)
+	}
+
+	bobs := 0
+	for _, v := range g.HallOfFame() {
+		if v.Name == "bob" {
+			bobs++
+		}
+		if v.Name == "alice" && (v.Seasons != 2 || v.Wins != 1 || v.LongestStreak != 1) {
+			t.Errorf("alice has stats %+v", v)
+		}
+	}
+	if bobs != 2 {
+		t.Errorf("The hall of fame has %d bobs, not 2", bobs)
+	}
+}
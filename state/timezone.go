@@ -0,0 +1,41 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // So that time zones work without the system's zoneinfo
+)
+
+const defaultTimeZone = "UTC"
+
+// TimeZone returns the IANA name of the game's time zone. Turns are
+// scheduled, and seasons end, according to the clocks in this zone.
+func (g *Game) TimeZone() string {
+	return g.gameString(nil, "TimeZone", defaultTimeZone)
+}
+
+// Location returns the game's time zone.
+func (g *Game) Location() *time.Location {
+	return g.location(nil)
+}
+
+func (g *Game) location(t *sql.Tx) *time.Location {
+	loc, err := time.LoadLocation(g.gameString(t, "TimeZone", defaultTimeZone))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SetTimeZone changes the game's time zone to the one with the IANA name
+// zone (for example, "America/New_York").
+func (g *Game) SetTimeZone(zone string) error {
+	zone = strings.TrimSpace(zone)
+	if _, err := time.LoadLocation(zone); err != nil || len(zone) < 1 {
+		return fmt.Errorf("Unknown time zone: %s", zone)
+	}
+	_, err := g.setGame.Exec("TimeZone", zone)
+	return err
+}
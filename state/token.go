@@ -0,0 +1,118 @@
+package state
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+)
+
+// API token scopes. A read-only token can look at the game, but can't
+// trade.
+const (
+	ScopeRead  = "read"
+	ScopeTrade = "trade"
+)
+
+// tokenPrefix marks a string as an API token, to tell it apart from a
+// session cookie.
+const tokenPrefix = "cp_"
+
+// Token describes one of a player's API tokens. The token itself is only
+// shown when it is created; the game keeps just a hash of it. LastUsed is
+// zero if the token has never been used.
+type Token struct {
+	ID       int64
+	Name     string
+	Scope    string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+func (g *Game) tokenHash(token []byte) []byte {
+	return KMAC128("token", g.getKey(), token, 256)
+}
+
+// IsToken reports whether token looks like an API token.
+func IsToken(token string) bool {
+	return len(token) > len(tokenPrefix) && token[:len(tokenPrefix)] == tokenPrefix
+}
+
+// NewToken creates an API token called name, with the given scope, and
+// returns it.
+func (p *PlayerInfo) NewToken(name, scope string) (string, error) {
+	if len(name) < 1 {
+		return "", errors.New("Please give the token a name")
+	}
+	if scope != ScopeRead && scope != ScopeTrade {
+		return "", errors.New("Unrecognized scope: " + scope)
+	}
+	token := make([]byte, 256/8)
+	rand.Read(token)
+	_, err := p.g.addToken.Exec(p.playerID, name, p.g.tokenHash(token), scope)
+	if err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+func (p *PlayerInfo) Tokens() []Token {
+	rv := make([]Token, 0)
+	r, err := p.g.getTokens.Query(p.playerID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var t Token
+		var created, used string
+		r.Scan(&t.ID, &t.Name, &t.Scope, &created, &used)
+		t.Created, _ = time.Parse(sqliteDate, created)
+		t.LastUsed, _ = time.Parse(sqliteDate, used)
+		rv = append(rv, t)
+	}
+	return rv
+}
+
+func (p *PlayerInfo) RevokeToken(id int64) error {
+	r := p.g.deleteToken.QueryRow(id, p.playerID)
+	err := r.Scan(&id)
+	if err == sql.ErrNoRows {
+		return errors.New("No such token")
+	}
+	return err
+}
+
+// PlayerByToken finds the player who owns the API token, and records that
+// the token was used.
+func (g *Game) PlayerByToken(token string) (string, *PlayerInfo) {
+	if !IsToken(token) {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token[len(tokenPrefix):])
+	if err != nil || len(raw) < 10 {
+		return "", nil
+	}
+	rv := PlayerInfo{g: g}
+	var name string
+	r := g.findPlayerByToken.QueryRow(g.tokenHash(raw))
+	err = r.Scan(&name, &rv.playerID, &rv.scope)
+	if err != nil {
+		return "", nil
+	}
+	return name, &rv
+}
+
+// ViaToken reports whether the player was found by an API token, rather
+// than by logging in.
+func (p *PlayerInfo) ViaToken() bool {
+	return len(p.scope) > 0
+}
+
+// CanTrade reports whether the player may trade. Players who were found by
+// a read-only API token can't.
+func (p *PlayerInfo) CanTrade() bool {
+	return p.scope != ScopeRead
+}
@@ -1,12 +1,10 @@
 package state
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
-	"sort"
-	"strings"
 	"time"
 
 	"golang.org/x/exp/slices"
@@ -14,127 +12,186 @@ import (
 
 const sqliteDate = "2006-01-02 15:04:05"
 
-func (g *Game) getPrevRun() (time.Time, error) {
-	r := g.getGame.QueryRow("Time")
-	prevString := ""
-	r.Scan(&prevString)
-	return time.Parse(sqliteDate, prevString)
+func (g *Game) getPrevRun(t *sql.Tx) (time.Time, error) {
+	return time.Parse(sqliteDate, g.gameString(t, "Time", ""))
 }
 
 func (g *Game) nextTurn() <-chan time.Time {
-	now := time.Now().UTC()
-	prev, err := g.getPrevRun()
-	if err != nil || prev.Day() != now.Day() {
+	now := time.Now()
+	prev, err := g.getPrevRun(nil)
+	if err != nil {
 		return time.After(1)
 	}
-
-	tomorrow := now.Add(time.Hour * 23)
-	for tomorrow.Day() == now.Day() {
-		tomorrow = tomorrow.Add(time.Hour)
+	next := g.schedule(nil).next(prev.In(g.Location()))
+	if next.IsZero() {
+		// The schedule has no more turns
+		return time.After(24 * time.Hour)
+	}
+	if !next.After(now) {
+		return time.After(1)
 	}
-	next := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, time.UTC)
 	return time.After(next.Sub(now))
 }
 
-func (g *Game) newDay() {
-	const rounds = 15
-	const (
-		up = iota
-		down
-		dividend
-	)
-
-	now := time.Now().UTC()
-	prev, err := g.getPrevRun()
-	if err != nil && prev.Day() == now.Day() {
-		// It's not quite tomorrow yet
-		return
-	}
+// marketDay applies rounds of the day's market adjustments to stocks, using
+// model and rng, and returns the news. If tx is not nil, the adjustments are
+// also applied to the game (standing orders are filled, holdings are
+// adjusted, and prices are recorded). The adjusted stocks are returned
+// along with the news.
+func (g *Game) marketDay(tx *sql.Tx, stocks []Stock, rules Rules, rounds uint64, model MarketModel, rng *rand.Rand, date string) ([]Stock, []string) {
+	before := slices.Clone(stocks)
+	after := slices.Clone(stocks)
 
-	for {
-		tx, _ := g.db.Begin()
+	divpaid := make([]uint64, len(before))
+	splits := make([]int, len(before))
+	open := make([]uint64, len(before))
+	for k, v := range before {
+		open[k] = v.Value
+	}
+	news := make([]string, 0, len(before))
 
-		before := g.ListStocks()
-		after := slices.Clone(before)
-
-		var divpaid [stockTypes]uint64
-		news := make([]string, 0, stockTypes)
-
-		for i := 0; i < rounds; i++ {
-			adjust := uint64(math.Pow(rand.Float64()*.8+1.2, 5.0))
-			stock := rand.Intn(stockTypes)
-			switch rand.Intn(3) {
-			case up:
-				after[stock].Value += adjust
-				if after[stock].Value >= splitValue {
-					news = append(news, after[stock].Name+" split 2 for 1")
-					after[stock].Value = (after[stock].Value + 1) / 2
-					before[stock].Value = (before[stock].Value + 1) / 2
-					tx.Stmt(g.splitStock).Exec(stock + 1)
+	for i := uint64(0); i < rounds; i++ {
+		for _, m := range model.Round(after, &rules, rng) {
+			stock := m.Stock
+			if stock < 0 || stock >= len(after) {
+				continue
+			}
+			if m.Kind == Fall && after[stock].Value <= m.Amount {
+				m.Kind = Bankrupt
+			}
+			switch m.Kind {
+			case Rise:
+				after[stock].Value += m.Amount
+				if tx != nil {
+					news = append(news, g.fireTriggers(tx, stock, after[stock].Name, after[stock].Value)...)
 				}
-			case down:
-				if after[stock].Value <= adjust {
-					news = append(news, after[stock].Name+" went bankrupt, and was removed from the market")
+			case Fall:
+				after[stock].Value -= m.Amount
+				if tx != nil {
+					news = append(news, g.fireTriggers(tx, stock, after[stock].Name, after[stock].Value)...)
+				}
+			case Split:
+				news = append(news, after[stock].Name+" split 2 for 1")
+				after[stock].Value = (after[stock].Value + 1) / 2
+				before[stock].Value = (before[stock].Value + 1) / 2
+				splits[stock]++
+				if tx != nil {
+					tx.Stmt(g.splitStock).Exec(stock+1, after[stock].Value)
+				}
+			case Bankrupt:
+				if tx != nil {
+					// Stop-loss orders get a chance to sell before the shares are lost
+					news = append(news, g.fireTriggers(tx, stock, after[stock].Name, 0)...)
+				}
+				news = append(news, after[stock].Name+" went bankrupt, and was removed from the market")
+				if tx != nil {
 					tx.Stmt(g.bankruptStock).Exec(stock + 1)
-					after[stock].Value = startingValue
-					before[stock].Value = startingValue
-					newname := g.pickName(tx)
-					news = append(news, newname+" was added to the market")
+					tx.Stmt(g.addPrice).Exec(stock+1, after[stock].Name, date, open[stock], 0, divpaid[stock], splits[stock], true)
+				}
+				open[stock] = rules.StartingValue
+				divpaid[stock] = 0
+				splits[stock] = 0
+				after[stock].Value = rules.StartingValue
+				before[stock].Value = rules.StartingValue
+				newname := pickName(rng, after)
+				news = append(news, newname+" was added to the market")
+				if tx != nil {
 					tx.Stmt(g.setStockName).Exec(stock+1, newname)
-					after[stock].Name = newname
-				} else {
-					after[stock].Value -= adjust
 				}
-			case dividend:
-				if after[stock].Value >= startingValue {
-					divpaid[stock] += adjust
-					tx.Stmt(g.dividendStock).Exec(stock+1, adjust)
+				after[stock].Name = newname
+			case Dividend:
+				divpaid[stock] += m.Amount
+				if tx != nil {
+					tx.Stmt(g.dividendStock).Exec(stock+1, m.Amount)
 				}
+			case News:
+				news = append(news, m.News)
 			}
 		}
-
-		for k, v := range after {
-			var item string
-			switch {
-			case v.Value == before[k].Value:
-				item = v.Name + " did not change price"
-			case v.Value < before[k].Value:
-				item = fmt.Sprintf("%s fell %.1f%%", v.Name, float64(before[k].Value-v.Value)/float64(before[k].Value)*100)
-			default: // case v.Value > before[k].Value:
-				item = fmt.Sprintf("%s rose %.1f%%", v.Name, float64(v.Value-before[k].Value)/float64(before[k].Value)*100)
+		if tx != nil {
+			for k, v := range after {
+				g.fillOrders(tx, k, v.Value)
 			}
-			if divpaid[k] > 0 {
-				item = fmt.Sprintf("%s, and paid $%d in dividends", item, divpaid[k])
-			}
-			news = append(news, item)
+			news = append(news, g.forceCover(tx, after)...)
+		}
+	}
+
+	for k, v := range after {
+		var item string
+		switch {
+		case v.Value == before[k].Value:
+			item = v.Name + " did not change price"
+		case v.Value < before[k].Value:
+			item = fmt.Sprintf("%s fell %.1f%%", v.Name, float64(before[k].Value-v.Value)/float64(before[k].Value)*100)
+		default: // case v.Value > before[k].Value:
+			item = fmt.Sprintf("%s rose %.1f%%", v.Name, float64(v.Value-before[k].Value)/float64(before[k].Value)*100)
+		}
+		if divpaid[k] > 0 {
+			item = fmt.Sprintf("%s, and paid $%d in dividends", item, divpaid[k])
+		}
+		news = append(news, item)
+		if tx != nil {
 			tx.Stmt(g.setStockValue).Exec(k+1, v.Value)
+			tx.Stmt(g.addPrice).Exec(k+1, v.Name, date, open[k], v.Value, divpaid[k], splits[k], false)
 		}
+	}
+	return after, news
+}
+
+func (g *Game) newDay() {
+	now := time.Now()
+	prev, err := g.getPrevRun(nil)
+	if next := g.schedule(nil).next(prev.In(g.Location())); err == nil && (next.IsZero() || now.Before(next)) {
+		// It's not quite time for the next turn yet
+		return
+	}
+	if now.Before(g.seasonStart(nil)) {
+		// Between seasons in the season calendar, so skip the turn
+		g.setGame.Exec("Time", now.UTC().Format(sqliteDate))
+		return
+	}
+	g.Advance(now)
+}
+
+// Advance plays the turn for time now, whether or not it is time for
+// another turn. The turn plays its share of the day's rounds, according to
+// the schedule.
+func (g *Game) Advance(now time.Time) {
+	loc := g.Location()
+	now = now.In(loc)
+	prev, err := g.getPrevRun(nil)
+	if err != nil {
+		prev = now
+	}
+	prev = prev.In(loc)
+
+	date := now.UTC().Format(sqliteDate)
+	name := g.MarketModel()
+	rules := g.Rules()
+	rounds := g.schedule(nil).roundsAt(now, rules.Rounds)
+	seed := g.turnSeed(date)
+	for {
+		tx, _ := g.db.Begin()
+
+		// Every turn gets its own seed, so that it can be replayed
+		rng := rand.New(rand.NewSource(seed))
+		tx.Stmt(g.addTurn).Exec(date, seed, name, rules.Rounds, rules.StartingValue, rules.SplitValue, rounds)
+		tx.Stmt(g.setGame).Exec("TurnSeed", seed)
+
+		tx.Stmt(g.expireOrders).Exec(date)
+		_, news := g.marketDay(tx, g.stocks(tx), rules, rounds, marketModels[name], rng, date)
 		tx.Exec("DELETE FROM News")
 		for _, n := range news {
 			tx.Stmt(g.addNews).Exec(n)
 		}
 
-		if now.Month() != prev.Month() {
-			leader := g.leaders(tx)
-			if len(leader) > 0 {
-				sort.Sort(LeaderSort(leader))
-				announce := fmt.Sprintf("The winner of the %s %d season was %s, with a net worth of $%d",
-					prev.Month().String(), prev.Year(), leader[0].Name, leader[0].Worth)
-				tx.Stmt(g.addNews).Exec(announce)
-				tx.Stmt(g.addHistory).Exec(announce)
-			}
-			if len(leader) > 1 {
-				rup := []string{}
-				for i := 1; i < len(leader); i++ {
-					rup = append(rup, fmt.Sprintf("%s had $%d", leader[i].Name, leader[i].Worth))
-				}
-				tx.Stmt(g.addNews).Exec(fmt.Sprintf("(%s)", strings.Join(rup, ", ")))
-			}
-			g.reset(tx)
+		if end := g.seasonEnd(tx); !end.IsZero() && !now.Before(end) {
+			g.endSeason(tx, end, now, rules, rng)
 		}
-		tx.Stmt(g.setGame).Exec("Time", now.Format(sqliteDate))
+		tx.Stmt(g.setGame).Exec("Time", date)
 		err = tx.Commit()
 		if err == nil {
+			g.Notify("turn")
 			return
 		}
 		log.Println(err)
@@ -0,0 +1,98 @@
+// Keeps the game page up to date, using the server-sent events from
+// /events. Without JavaScript, the page still works; it just has to be
+// reloaded to see the latest news and prices.
+(function () {
+  if (!window.EventSource || !document.getElementById("holdings")) {
+    return;
+  }
+
+  var thinsp = " ";
+  function money(n) {
+    var s = Math.abs(n).toString().replace(/\B(?=(\d{3})+(?!\d))/g, thinsp);
+    return (n < 0 ? "-$" : "$") + s;
+  }
+
+  function cell(row, text, colspan) {
+    var td = document.createElement("td");
+    if (colspan) {
+      td.colSpan = colspan;
+    }
+    if (text instanceof Node) {
+      td.appendChild(text);
+    } else {
+      td.textContent = text;
+    }
+    row.appendChild(td);
+  }
+
+  function replaceRows(tbody, rows) {
+    while (tbody.firstChild) {
+      tbody.removeChild(tbody.firstChild);
+    }
+    rows.forEach(function (r) { tbody.appendChild(r); });
+  }
+
+  function showLeaders(leaders) {
+    replaceRows(document.getElementById("leaders"), leaders.map(function (l) {
+      var tr = document.createElement("tr");
+      cell(tr, l.name);
+      cell(tr, money(l.worth));
+      return tr;
+    }));
+  }
+
+  function showNews(news) {
+    var p = document.getElementById("news");
+    while (p.firstChild) {
+      p.removeChild(p.firstChild);
+    }
+    news.forEach(function (n) {
+      p.appendChild(document.createTextNode(n));
+      p.appendChild(document.createElement("br"));
+    });
+  }
+
+  function showPortfolio(pf) {
+    var rows = pf.holdings.map(function (h) {
+      var tr = document.createElement("tr");
+      var a = document.createElement("a");
+      a.href = "/stock?name=" + encodeURIComponent(h.stock);
+      a.textContent = h.stock;
+      cell(tr, a);
+      cell(tr, money(h.price));
+      cell(tr, h.shares + (h.short ? " (short " + h.short + ")" : ""));
+      cell(tr, money((h.shares - h.short) * h.price));
+      return tr;
+    });
+    [["Cash on Hand", pf.cash], ["Net Worth", pf.net_worth]].forEach(function (v) {
+      var tr = document.createElement("tr");
+      cell(tr, v[0], 2);
+      cell(tr, money(v[1]), 2);
+      rows.push(tr);
+    });
+    replaceRows(document.getElementById("holdings"), rows);
+
+    // Stocks are renamed when they go bankrupt
+    var selects = document.querySelectorAll("select[name=stock]");
+    Array.prototype.forEach.call(selects, function (sel) {
+      var chosen = sel.value;
+      while (sel.firstChild) {
+        sel.removeChild(sel.firstChild);
+      }
+      pf.holdings.forEach(function (h) {
+        var opt = document.createElement("option");
+        opt.value = opt.textContent = h.stock;
+        opt.selected = h.stock === chosen;
+        sel.appendChild(opt);
+      });
+    });
+  }
+
+  var events = new EventSource("/events");
+  events.onmessage = function (e) {
+    var snap = JSON.parse(e.data);
+    showLeaders(snap.leaders);
+    showNews(snap.news);
+    showPortfolio(snap.portfolio);
+  };
+})();
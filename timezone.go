@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func timezone() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	zone := flag.Arg(1)
+	if len(zone) < 1 {
+		fmt.Println("Time zone:", game.TimeZone())
+		return
+	}
+	if err := game.SetTimeZone(zone); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/peterh/comprod2/state"
+)
+
+func unlock() {
+	game := state.Open(*data)
+	if game == nil {
+		fmt.Println("Unable to open game", *data)
+		return
+	}
+	defer game.Close()
+	subject := flag.Arg(1)
+	if len(subject) < 1 {
+		for _, v := range game.Lockouts() {
+			fmt.Printf("%s %s: %d failed logins, locked until %s\n", v.Kind, v.Subject, v.Failures, v.Until.In(game.Location()).Format("2006-01-02 15:04"))
+		}
+		for _, v := range game.AuditLog(auditEntries) {
+			fmt.Printf("%s %s %s: %s\n", v.Date.In(game.Location()).Format("2006-01-02 15:04"), v.Event, v.Subject, v.Detail)
+		}
+		return
+	}
+	kind := "account"
+	if net.ParseIP(subject) != nil {
+		kind = "address"
+	}
+	if err := game.Unlock(kind, subject, "the command line"); err != nil {
+		fmt.Println(err)
+	}
+}